@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"hosting/internal/config"
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/storage"
+	"hosting/internal/telegram"
+)
+
+// 将文件从一个存储后端复制到另一个后端，公开的 /file/... 地址保持不变。
+// 原图和缩略图等派生图片一起迁移，去重后共享的对象只复制一次，所有引用它的记录一起更新。
+// 只处理仍位于源后端的对象，中断后重新运行即可从未完成的对象继续。
+//
+//	migrate -from telegram -to local
+//	migrate -from telegram -to telegram -chat -1001234567890
+func main() {
+	from := flag.String("from", "", "源存储后端: telegram、local 或 s3")
+	to := flag.String("to", "", "目标存储后端: telegram、local 或 s3")
+	chatID := flag.Int64("chat", 0, "目标为 telegram 时写入的频道，默认按配置选择")
+	limit := flag.Int("limit", 0, "最多迁移的对象数，0 表示不限制")
+	dryRun := flag.Bool("dry-run", false, "只列出待迁移的对象")
+	deleteSource := flag.Bool("delete-source", false, "迁移后删除源后端中不再被引用的对象，包括分片文件的各分片和清单")
+	flag.StringVar(&global.ConfigFile, "config", global.ConfigFile, "配置文件路径")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *from == *to && (*to != "telegram" || *chatID == 0) {
+		log.Fatal("Source and target are the same; use -chat to move between telegram channels")
+	}
+
+	config.LoadConfig()
+	db.InitDB()
+	defer global.DB.Close()
+
+	if *from == "telegram" || *to == "telegram" {
+		telegram.Connect()
+	}
+
+	source, err := storage.New(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var target storage.Backend
+	if *to == "telegram" && *chatID != 0 {
+		target = storage.NewTelegramWithChats([]int64{*chatID})
+	} else if target, err = storage.New(*to); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var migrated, failed int
+	var lastKey string
+	for *limit == 0 || migrated+failed < *limit {
+		batch, err := pendingObjects(ctx, *from, *chatID, lastKey)
+		if err != nil {
+			log.Fatal("Failed to query objects:", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, obj := range batch {
+			lastKey = obj.fileID
+			if *limit > 0 && migrated+failed >= *limit {
+				break
+			}
+			if ctx.Err() != nil {
+				log.Printf("Interrupted, migrated %d, failed %d", migrated, failed)
+				return
+			}
+			if *dryRun {
+				log.Printf("Would migrate object %s (%d references)", obj.fileID, obj.refs)
+				migrated++
+				continue
+			}
+
+			n, err := migrateObject(ctx, source, target, obj)
+			if err != nil {
+				log.Printf("Failed to migrate object %s: %v", obj.fileID, err)
+				failed++
+				continue
+			}
+			migrated++
+			log.Printf("Migrated object %s, updated %d records", obj.fileID, n)
+
+			if *deleteSource {
+				removeSource(ctx, source, obj)
+			}
+		}
+	}
+
+	log.Printf("Done, migrated %d, failed %d", migrated, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// object 源后端中的一个对象，可能被多条原图和派生图片记录引用
+type object struct {
+	fileID      string
+	filename    string
+	contentType string
+	chatID      int64
+	messageID   int64
+	refs        int
+}
+
+// pendingObjects 按 file_id 分批查询仍位于源后端的对象，相同对象只返回一次
+func pendingObjects(ctx context.Context, from string, chatID int64, afterKey string) ([]object, error) {
+	query := `
+		SELECT file_id, MAX(filename), MAX(content_type), MAX(chat_id), MAX(message_id), COUNT(*) FROM (
+			SELECT file_id, filename, content_type, chat_id, message_id FROM images WHERE storage = ?
+			UNION ALL
+			SELECT file_id, variant, content_type, chat_id, message_id FROM image_variants WHERE storage = ?
+		) WHERE file_id != '' AND file_id > ?`
+	args := []any{from, from, afterKey}
+	// 频道间迁移时，已在目标频道的对象视为已完成
+	if from == "telegram" && chatID != 0 {
+		query += " AND chat_id != ?"
+		args = append(args, chatID)
+	}
+	query += " GROUP BY file_id ORDER BY file_id LIMIT 100"
+
+	rows, err := global.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []object
+	for rows.Next() {
+		var obj object
+		if err := rows.Scan(&obj.fileID, &obj.filename, &obj.contentType, &obj.chatID, &obj.messageID, &obj.refs); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// migrateObject 复制单个对象，校验目标内容后更新所有引用它的记录，返回更新的记录数
+func migrateObject(ctx context.Context, source, target storage.Backend, src object) (int64, error) {
+	tmp, err := os.CreateTemp("", "migrate-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	body, _, err := source.Get(ctx, src.fileID)
+	if err != nil {
+		return 0, fmt.Errorf("read source: %w", err)
+	}
+	sourceHash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, sourceHash), body)
+	body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("read source: %w", err)
+	}
+	checksum := hex.EncodeToString(sourceHash.Sum(nil))
+
+	obj, err := target.Put(ctx, src.filename, io.NewSectionReader(tmp, 0, size), size, src.contentType)
+	if err != nil {
+		return 0, fmt.Errorf("write target: %w", err)
+	}
+
+	if err := verify(ctx, target, obj.Key, size, checksum); err != nil {
+		discard(target, obj)
+		return 0, err
+	}
+
+	// 仅更新仍指向源对象的记录，避免覆盖并发修改
+	var updated int64
+	err = func() error {
+		tx, err := global.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, update := range []struct {
+			query string
+			args  []any
+		}{
+			{`UPDATE images SET storage = ?, file_id = ?, telegram_url = ?, bot_id = ?, chat_id = ?, message_id = ?
+				WHERE storage = ? AND file_id = ?`,
+				[]any{target.Name(), obj.Key, obj.URL, obj.BotID, obj.ChatID, obj.MessageID, source.Name(), src.fileID}},
+			{`UPDATE image_variants SET storage = ?, file_id = ?, chat_id = ?, message_id = ?
+				WHERE storage = ? AND file_id = ?`,
+				[]any{target.Name(), obj.Key, obj.ChatID, obj.MessageID, source.Name(), src.fileID}},
+		} {
+			result, err := tx.ExecContext(ctx, update.query, update.args...)
+			if err != nil {
+				return err
+			}
+			n, _ := result.RowsAffected()
+			updated += n
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		discard(target, obj)
+		return 0, fmt.Errorf("update records: %w", err)
+	}
+	if updated == 0 {
+		discard(target, obj)
+		return 0, errors.New("records changed during migration")
+	}
+	return updated, nil
+}
+
+// removeSource 删除源后端中已不再被任何记录引用的对象，副本记录仍引用时保留
+func removeSource(ctx context.Context, source storage.Backend, src object) {
+	var referenced bool
+	err := global.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM images WHERE storage = ? AND file_id = ?)
+			OR EXISTS (SELECT 1 FROM image_variants WHERE storage = ? AND file_id = ?)
+			OR EXISTS (SELECT 1 FROM image_replicas WHERE storage = ? AND file_id = ?)`,
+		source.Name(), src.fileID, source.Name(), src.fileID, source.Name(), src.fileID,
+	).Scan(&referenced)
+	if err != nil {
+		log.Printf("Failed to check references of %s: %v", src.fileID, err)
+		return
+	}
+	if referenced {
+		log.Printf("Keeping source object %s, still referenced", src.fileID)
+		return
+	}
+	err = storage.DeleteObject(ctx, source, &storage.Object{Key: src.fileID, ChatID: src.chatID, MessageID: src.messageID})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to delete source object %s: %v", src.fileID, err)
+	}
+}
+
+// verify 重新读取目标对象并比较长度和 SHA-256
+func verify(ctx context.Context, b storage.Backend, key string, size int64, checksum string) error {
+	body, _, err := b.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("verify target: %w", err)
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, body)
+	if err != nil {
+		return fmt.Errorf("verify target: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("verify target: size mismatch, expected %d, got %d", size, n)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != checksum {
+		return fmt.Errorf("verify target: checksum mismatch, expected %s, got %s", checksum, got)
+	}
+	return nil
+}
+
+// discard 尽力删除校验失败或未被使用的目标对象
+func discard(b storage.Backend, obj *storage.Object) {
+	if err := storage.DeleteObject(context.Background(), b, obj); err != nil && !errors.Is(err, storage.ErrNotSupported) {
+		log.Printf("Failed to remove target object %s: %v", obj.Key, err)
+	}
+}
@@ -15,12 +15,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 
+	"hosting/internal/cache"
 	"hosting/internal/config"
 	"hosting/internal/db"
 	"hosting/internal/global"
 	"hosting/internal/handlers"
 	"hosting/internal/middleware"
+	"hosting/internal/resumable"
+	"hosting/internal/storage"
 	"hosting/internal/telegram"
+	"hosting/internal/utils"
 )
 
 func main() {
@@ -33,6 +37,18 @@ func main() {
 	// 初始化 Telegram bot
 	telegram.InitTelegram()
 
+	// 初始化存储后端
+	storage.InitStorage()
+
+	// 初始化文件缓存
+	cache.InitCache()
+
+	// 初始化断点续传
+	resumable.InitResumable()
+
+	// 后台为旧记录补充感知哈希
+	go handlers.BackfillPerceptualHashes()
+
 	// 生成随机 session secret
 	var sessionSecret []byte
 	if global.AppConfig.Security.SessionSecret != "" {
@@ -67,8 +83,13 @@ func main() {
 		}
 	}
 
+	// 初始化限流，客户端地址按受信任的反向代理解析
+	utils.InitTrustedProxies()
+	middleware.InitRateLimit()
+
 	// 创建全局上传信号量
 	global.UploadSemaphore = make(chan struct{}, global.MaxConcurrentUploads)
+	global.VariantSemaphore = make(chan struct{}, global.MaxConcurrentVariants)
 
 	r := mux.NewRouter()
 
@@ -79,13 +100,33 @@ func main() {
 
 	// 路由设置
 	r.HandleFunc("/", handlers.HandleHome).Methods("GET")
-	r.HandleFunc("/upload", handlers.HandleUpload).Methods("POST")
-	r.HandleFunc("/file/{uuid}", handlers.HandleImage).Methods("GET")
+	r.HandleFunc("/upload", middleware.RateLimit(handlers.HandleUpload)).Methods("POST")
+	r.HandleFunc("/upload/url", middleware.RateLimit(handlers.HandleRemoteUpload)).Methods("POST")
+	r.HandleFunc("/upload/result", handlers.HandleResumableResult).Methods("GET")
+	r.HandleFunc("/tus/", middleware.RateLimit(handlers.HandleTusCreate)).Methods("POST")
+	r.HandleFunc("/tus/", handlers.HandleTusOptions).Methods("OPTIONS")
+	r.HandleFunc("/tus/{id}", handlers.HandleTusOptions).Methods("OPTIONS")
+	r.HandleFunc("/tus/{id}", handlers.HandleTusHead).Methods("HEAD")
+	r.HandleFunc("/tus/{id}", handlers.HandleTusPatch).Methods("PATCH")
+	r.HandleFunc("/tus/{id}", handlers.HandleTusDelete).Methods("DELETE")
+	r.HandleFunc("/file/{uuid}", handlers.HandleImage).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/upload", middleware.RateLimit(handlers.HandleAPIUpload)).Methods("POST")
+	r.HandleFunc("/api/v1/upload/url", middleware.RateLimit(handlers.HandleAPIRemoteUpload)).Methods("POST")
+	r.HandleFunc("/api/v1/delete/{token}", handlers.HandleAPIDelete).Methods("POST", "DELETE")
 	r.HandleFunc("/login", handlers.HandleLoginPage).Methods("GET")
-	r.HandleFunc("/login", handlers.HandleLogin).Methods("POST")
+	r.HandleFunc("/login", middleware.RateLimit(handlers.HandleLogin)).Methods("POST")
 	r.HandleFunc("/logout", handlers.HandleLogout).Methods("GET")
 	r.HandleFunc("/admin", middleware.RequireAuth(handlers.HandleAdmin)).Methods("GET")
+	r.HandleFunc("/admin/thumb/{id}", middleware.RequireAuth(handlers.HandleAdminThumbnail)).Methods("GET", "HEAD")
 	r.HandleFunc("/admin/toggle/{id}", middleware.RequireAuth(handlers.HandleToggleStatus)).Methods("POST")
+	r.HandleFunc("/admin/keys", middleware.RequireAuth(handlers.HandleAdminKeys)).Methods("GET", "POST")
+	r.HandleFunc("/admin/keys/{id}/revoke", middleware.RequireAuth(handlers.HandleRevokeKey)).Methods("POST")
+	r.HandleFunc("/admin/purge", middleware.RequireAuth(handlers.HandlePurge)).Methods("POST")
+	r.HandleFunc("/admin/ban/{id}", middleware.RequireAuth(handlers.HandleBanImage)).Methods("POST")
+	r.HandleFunc("/admin/blocked", middleware.RequireAuth(handlers.HandleAdminBlocked)).Methods("GET")
+	r.HandleFunc("/admin/blocked/{id}/delete", middleware.RequireAuth(handlers.HandleUnblock)).Methods("POST")
+	r.HandleFunc("/admin/similar", middleware.RequireAuth(handlers.HandleAdminSimilar)).Methods("GET")
+	r.HandleFunc("/admin/similar/keep", middleware.RequireAuth(handlers.HandleKeepSimilar)).Methods("POST")
 
 	// 服务器配置
 	port := global.AppConfig.Site.Port
@@ -3,10 +3,12 @@ module hosting
 go 1.23.4
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/sessions v1.4.0
+	golang.org/x/image v0.30.0
 	modernc.org/sqlite v1.34.3
 )
 
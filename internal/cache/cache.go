@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hosting/internal/global"
+)
+
+// Default 全局磁盘缓存，未启用时为 nil
+var Default *DiskCache
+
+// DiskCache 有容量和时效上限的 LRU 磁盘缓存，文件名为 key 的 SHA-256
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // 最近使用的在前
+	items map[string]*list.Element
+	size  int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type entry struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+// Stats 缓存统计信息
+type Stats struct {
+	Hits     int64
+	Misses   int64
+	Entries  int
+	Size     int64
+	MaxBytes int64
+}
+
+// InitCache 根据配置创建磁盘缓存
+func InitCache() {
+	cfg := global.AppConfig.Cache
+	if !cfg.Enabled {
+		return
+	}
+
+	dir := cfg.Path
+	if dir == "" {
+		dir = "./cache"
+	}
+	maxSize := 1024
+	if cfg.MaxSize > 0 {
+		maxSize = cfg.MaxSize
+	}
+	maxAge := 7 * 24 * time.Hour
+	if cfg.MaxAge != "" {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			log.Fatal("Invalid cache maxAge:", err)
+		}
+		maxAge = d
+	}
+
+	var err error
+	Default, err = New(dir, int64(maxSize)*1024*1024, maxAge)
+	if err != nil {
+		log.Fatal("Failed to initialize cache:", err)
+	}
+	go Default.janitor(10 * time.Minute)
+}
+
+// New 创建缓存并加载目录中已有的文件
+func New(dir string, maxBytes int64, maxAge time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+
+	// 按修改时间从旧到新加入，使最近写入的位于前面
+	var existing []entry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		existing = append(existing, entry{name: name, size: info.Size(), created: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].created.Before(existing[j].created)
+	})
+	c.mu.Lock()
+	for _, e := range existing {
+		c.add(e)
+	}
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Open 打开缓存的文件，未命中或已过期时返回 false
+func (c *DiskCache) Open(key string) (*os.File, bool) {
+	name := hashKey(key)
+
+	c.mu.Lock()
+	el, ok := c.items[name]
+	if ok && c.expired(el.Value.(*entry)) {
+		c.remove(el)
+		ok = false
+	}
+	if ok {
+		c.ll.MoveToFront(el)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	f, err := os.Open(c.path(name))
+	if err != nil {
+		c.mu.Lock()
+		if el, ok := c.items[name]; ok {
+			c.remove(el)
+		}
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return f, true
+}
+
+// Create 返回写入缓存的 Writer，写完后调用 Commit，失败时调用 Abort
+func (c *DiskCache) Create(key string) (*Writer, error) {
+	name := hashKey(key)
+	if err := os.MkdirAll(filepath.Dir(c.path(name)), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path(name)), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{cache: c, name: name, file: f}, nil
+}
+
+// Remove 删除指定 key 的缓存
+func (c *DiskCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[hashKey(key)]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *DiskCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  c.ll.Len(),
+		Size:     c.size,
+		MaxBytes: c.maxBytes,
+	}
+}
+
+// janitor 定期清理过期文件
+func (c *DiskCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		for el := c.ll.Back(); el != nil; {
+			prev := el.Prev()
+			if c.expired(el.Value.(*entry)) {
+				c.remove(el)
+			}
+			el = prev
+		}
+		c.mu.Unlock()
+	}
+}
+
+// add 加入条目，调用方需持有锁
+func (c *DiskCache) add(e entry) {
+	if el, ok := c.items[e.name]; ok {
+		c.size -= el.Value.(*entry).size
+		c.ll.Remove(el)
+	}
+	c.items[e.name] = c.ll.PushFront(&e)
+	c.size += e.size
+}
+
+// evict 淘汰最久未使用的条目直到容量达标，调用方需持有锁
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.ll.Len() > 0 {
+		c.remove(c.ll.Back())
+	}
+}
+
+// remove 删除条目及文件，调用方需持有锁
+func (c *DiskCache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.ll.Remove(el)
+	delete(c.items, e.name)
+	c.size -= e.size
+	os.Remove(c.path(e.name))
+}
+
+func (c *DiskCache) expired(e *entry) bool {
+	return c.maxAge > 0 && time.Since(e.created) > c.maxAge
+}
+
+func (c *DiskCache) path(name string) string {
+	return filepath.Join(c.dir, name[0:2], name)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Writer 边下载边写入缓存，超过缓存容量时放弃写入
+type Writer struct {
+	cache   *DiskCache
+	name    string
+	file    *os.File
+	written int64
+	failed  bool
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+	w.written += int64(len(p))
+	if w.written > w.cache.maxBytes {
+		w.failed = true
+		return len(p), nil
+	}
+	if _, err := w.file.Write(p); err != nil {
+		w.failed = true
+	}
+	// 缓存写入失败不影响向客户端输出
+	return len(p), nil
+}
+
+// Commit 完成写入并加入缓存
+func (w *Writer) Commit() error {
+	if w.failed {
+		w.Abort()
+		return nil
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	if err := os.Rename(w.file.Name(), w.cache.path(w.name)); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	w.cache.mu.Lock()
+	w.cache.add(entry{name: w.name, size: w.written, created: time.Now()})
+	w.cache.evict()
+	w.cache.mu.Unlock()
+	return nil
+}
+
+// Abort 放弃写入
+func (w *Writer) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
@@ -38,7 +39,11 @@ func InitDB() {
 		content_type TEXT NOT NULL,
 		is_active BOOLEAN DEFAULT 1,
 		view_count INTEGER DEFAULT 0,
-		file_id TEXT NOT NULL
+		file_id TEXT NOT NULL,
+		storage TEXT NOT NULL DEFAULT 'telegram',
+		bot_id INTEGER NOT NULL DEFAULT 0,
+		chat_id INTEGER NOT NULL DEFAULT 0,
+		file_size INTEGER NOT NULL DEFAULT 0
 	)`)
 
 	if err != nil {
@@ -55,10 +60,149 @@ func InitDB() {
 		log.Fatal(err)
 	}
 
+	// 副本存储状态，status 为 ok 或 failed
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS image_replicas (
+		image_id INTEGER NOT NULL,
+		storage TEXT NOT NULL,
+		file_id TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (image_id, storage)
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 大文件分片清单，file_key 对应 images.file_id
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS file_parts (
+		file_key TEXT NOT NULL,
+		part_index INTEGER NOT NULL,
+		part_key TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY (file_key, part_index)
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 缩放、转换格式后的派生图片，variant 为参数组合
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS image_variants (
+		image_id INTEGER NOT NULL,
+		variant TEXT NOT NULL,
+		storage TEXT NOT NULL,
+		file_id TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		file_size INTEGER NOT NULL DEFAULT 0,
+		width INTEGER NOT NULL DEFAULT 0,
+		height INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (image_id, variant)
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 封禁图片的感知哈希，相似的图片禁止上传
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS blocked_hashes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		phash TEXT NOT NULL UNIQUE,
+		image_id INTEGER NOT NULL DEFAULT 0,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 封禁图片的 SHA-256，完全相同的文件禁止上传。感知哈希相同的多个文件共用一条封禁记录
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS blocked_checksums (
+		sha256 TEXT PRIMARY KEY,
+		blocked_id INTEGER NOT NULL
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 因匹配封禁图片被拒绝的上传记录
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS blocked_uploads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blocked_id INTEGER NOT NULL,
+		phash TEXT NOT NULL,
+		distance INTEGER NOT NULL,
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		filename TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// API 密钥，只保存 SHA-256 哈希
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		revoked BOOLEAN DEFAULT 0
+	)`)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 旧版本数据库补充新增列
+	for _, col := range []struct{ name, definition string }{
+		{"storage", "TEXT NOT NULL DEFAULT 'telegram'"},
+		{"bot_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"chat_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"file_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"phash", "TEXT NOT NULL DEFAULT ''"}, // 感知哈希，空字符串表示尚未计算，"-" 表示无法计算
+		{"message_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"api_key_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"delete_token", "TEXT NOT NULL DEFAULT ''"}, // 删除凭据的 SHA-256 哈希
+	} {
+		if err := addColumn("images", col.name, col.definition); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 保存在 Telegram 的副本、分片和派生图片记录频道消息，用于永久删除
+	for _, table := range []string{"file_parts", "image_replicas", "image_variants"} {
+		for _, column := range []string{"chat_id", "message_id"} {
+			if err := addColumn(table, column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_sha256 ON images(sha256)`,
+		`CREATE INDEX IF NOT EXISTS idx_delete_token ON images(delete_token)`,
+		`CREATE INDEX IF NOT EXISTS idx_blocked_checksums ON blocked_checksums(blocked_id)`,
+	} {
+		if _, err := global.DB.Exec(index); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// 设置数据库连接池参数
 	maxOpenConns := 25
 	if global.AppConfig.Database.MaxOpenConns > 0 {
@@ -85,6 +229,38 @@ func InitDB() {
 	}
 }
 
+// addColumn 在列不存在时为表添加列
+func addColumn(table, column, definition string) error {
+	rows, err := global.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = global.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // 数据库操作超时包装函数
 func WithDBTimeout(f func(context.Context) error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), global.DBTimeout)
@@ -2,6 +2,7 @@ package global
 
 import (
 	"database/sql"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,28 +14,45 @@ var (
 	// 全局变量
 	DB        *sql.DB
 	AppConfig Config
-	Bot       *tgbotapi.BotAPI
+	Bot       *tgbotapi.BotAPI      // 主 bot，即 telegram.token 对应的 bot
+	Bots      []*tgbotapi.BotAPI    // 所有可用的 bot，包含主 bot
 	Store     *sessions.CookieStore // 移除初始化，将在 main 中进行
 
 	// 并发控制
-	UploadMutex     sync.Mutex    // 用于限制并发上传
-	UploadSemaphore chan struct{} // 用于限制并发上传
+	UploadMutex      sync.Mutex    // 用于限制并发上传
+	UploadSemaphore  chan struct{} // 用于限制并发上传
+	VariantSemaphore chan struct{} // 用于限制同时生成的派生图片数
 
 	// 程序配置
-	ConfigFile           = "./config.json"
-	StaticDir            = "./static"
-	MaxConcurrentUploads = 5
-	DBTimeout            = 10 * time.Second
-	UploadTimeout        = 30 * time.Second
+	ConfigFile            = "./config.json"
+	StaticDir             = "./static"
+	MaxConcurrentUploads  = 5
+	MaxConcurrentVariants = 4
+	DBTimeout             = 10 * time.Second
+	UploadTimeout         = 30 * time.Second
+	DefaultChunkSize      = 19                // MB，Bot API getFile 只能下载 20MB 以内的文件
+	DefaultMaxPixels      = int64(50_000_000) // 图片允许的最大像素数，防止解压炸弹
+	DefaultMaxFiles       = 20                // 一次请求最多上传的文件数
+	DefaultSimilarity     = 6                 // 感知哈希汉明距离不超过该值时视为相似图片
+	MaxSimilarity         = 10                // 相似图片分组的最大距离，距离越大分桶越宽，比较次数接近两两比较
 
-	// 允许的文件类型
-	AllowedMimeTypes = map[string]string{
-		"image/jpeg": ".jpg",
-		"image/jpg":  ".jpg",
-		"image/png":  ".png",
-		"image/gif":  ".gif",
-		"image/webp": ".webp",
+	// 支持的文件类型及扩展名
+	MimeTypeExtensions = map[string]string{
+		"image/jpeg":    ".jpg",
+		"image/jpg":     ".jpg",
+		"image/png":     ".png",
+		"image/gif":     ".gif",
+		"image/webp":    ".webp",
+		"image/avif":    ".avif",
+		"image/heic":    ".heic",
+		"image/heif":    ".heif",
+		"image/bmp":     ".bmp",
+		"image/tiff":    ".tiff",
+		"image/x-icon":  ".ico",
+		"image/svg+xml": ".svg",
 	}
+	// 未配置 image.allowedTypes 时允许上传的类型
+	DefaultAllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
 
 	CurrentUploads int
 	IsDevelopment  = true // 添加开发环境标志
@@ -56,13 +74,76 @@ const (
 // Config 应用配置结构
 type Config struct {
 	Telegram struct {
-		Token  string `json:"token"`
-		ChatID int64  `json:"chatId"`
+		Token     string   `json:"token"`
+		ChatID    int64    `json:"chatId"`
+		Tokens    []string `json:"tokens"`    // 额外的 bot，需要同为各频道管理员
+		ChatIDs   []int64  `json:"chatIds"`   // 额外的频道
+		Placement string   `json:"placement"` // 多频道写入策略: roundrobin（默认）或 hash
+		ChunkSize int      `json:"chunkSize"` // 分片大小（MB），超过此大小的文件拆分为多个文档保存，默认 19
+		// 自建 Bot API 服务器地址，如 http://127.0.0.1:8081，默认 api.telegram.org
+		APIEndpoint  string `json:"apiEndpoint"`
+		FileEndpoint string `json:"fileEndpoint"` // 文件下载地址，默认跟随 apiEndpoint
 	} `json:"telegram"`
 	Admin struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	} `json:"admin"`
+	Storage struct {
+		Type     string   `json:"type"`     // 存储后端: telegram（默认）、local 或 s3
+		Replicas []string `json:"replicas"` // 副本后端，上传时同时写入，主存储读取失败时使用
+		Local    struct {
+			Path string `json:"path"` // 本地存储目录，默认 ./data
+		} `json:"local"`
+		S3 struct {
+			Endpoint      string `json:"endpoint"`
+			Region        string `json:"region"`
+			Bucket        string `json:"bucket"`
+			Prefix        string `json:"prefix"`
+			AccessKey     string `json:"accessKey"`
+			SecretKey     string `json:"secretKey"`
+			VirtualHost   bool   `json:"virtualHost"`   // 使用 bucket.endpoint 形式访问
+			Redirect      bool   `json:"redirect"`      // 访问文件时跳转到预签名地址而不是代理
+			PresignExpiry string `json:"presignExpiry"` // 预签名地址有效期，默认 1h
+		} `json:"s3"`
+	} `json:"storage"`
+	Cache struct {
+		Enabled bool   `json:"enabled"`
+		Path    string `json:"path"`    // 缓存目录，默认 ./cache
+		MaxSize int    `json:"maxSize"` // 缓存容量（MB），默认 1024
+		MaxAge  string `json:"maxAge"`  // 缓存有效期，默认 168h
+	} `json:"cache"`
+	Resumable struct {
+		Path     string `json:"path"`     // 断点续传未完成文件的保存目录，默认 ./uploads
+		MaxSize  int    `json:"maxSize"`  // 未完成上传已接收内容占用的总容量（MB），默认 1024
+		Expiry   string `json:"expiry"`   // 未完成的上传超过该时间没有继续时删除，默认 24h
+		MaxPerIP int    `json:"maxPerIP"` // 同一地址未完成上传的最大数量，默认 10
+	} `json:"resumable"`
+	Image struct {
+		// 允许的缩放参数组合，键为预设名称，可通过 ?preset=名称 或完全相同的参数访问
+		Presets map[string]ImagePreset `json:"presets"`
+		// 上传时生成的缩略图预设名称，第一个用于管理页面预览，默认 thumb
+		Thumbnails []string `json:"thumbnails"`
+		// 根据 Accept 请求头为 JPEG/PNG/GIF 原图输出体积更小的 WebP 版本
+		Negotiate bool `json:"negotiate"`
+		// 上传时移除 EXIF/XMP/IPTC 等元数据，JPEG 先按 EXIF 方向旋转
+		StripMetadata bool     `json:"stripMetadata"`
+		KeepMetadata  []string `json:"keepMetadata"` // 移除时保留的元数据：icc、exif、xmp、iptc、comment，默认 ["icc"]
+		// 允许上传的 MIME 类型，默认 JPEG、PNG、GIF 和 WebP
+		AllowedTypes []string `json:"allowedTypes"`
+		// 上传图片的最大尺寸，超过时拒绝上传
+		MaxWidth  int   `json:"maxWidth"`
+		MaxHeight int   `json:"maxHeight"`
+		MaxPixels int64 `json:"maxPixels"` // 最大像素数，默认 5000 万
+		// 感知哈希（64 位）汉明距离不超过该值时视为相似图片，默认 6
+		Similarity int `json:"similarity"`
+		// 上传图片与封禁图片的汉明距离不超过该值时拒绝上传，默认与 similarity 相同
+		BlockDistance int `json:"blockDistance"`
+	} `json:"image"`
+	Files struct {
+		Enabled bool     `json:"enabled"` // 允许上传图片以外的文件，作为附件下载
+		Allow   []string `json:"allow"`   // 允许的 MIME 类型或扩展名，支持 video/* 形式的通配，为空时允许所有类型
+		Deny    []string `json:"deny"`    // 禁止的 MIME 类型或扩展名，优先于 allow
+	} `json:"files"`
 	Database struct {
 		Path            string `json:"path"`
 		MaxOpenConns    int    `json:"maxOpenConns"`
@@ -73,6 +154,7 @@ type Config struct {
 		Name        string `json:"name"`
 		Favicon     string `json:"favicon"`
 		MaxFileSize int    `json:"maxFileSize"`
+		MaxFiles    int    `json:"maxFiles"` // 一次请求最多上传的文件数，默认 20
 		Port        int    `json:"port"`
 		Host        string `json:"host"`
 	} `json:"site"`
@@ -84,10 +166,176 @@ type Config struct {
 		} `json:"rateLimit"`
 		AllowedHosts  []string `json:"allowedHosts"`
 		SessionSecret string   `json:"sessionSecret"` // 添加 session secret 配置
+
+		// 反向代理的地址或网段，只有来自这些地址的请求才使用 X-Forwarded-For 判断客户端地址
+		TrustedProxies []string `json:"trustedProxies"`
 	} `json:"security"`
 	Environment string `json:"environment"` // 可选值: "development" 或 "production"
 }
 
+// StorageType 返回主存储后端名称
+func (c *Config) StorageType() string {
+	if c.Storage.Type == "" {
+		return "telegram"
+	}
+	return c.Storage.Type
+}
+
+// StorageBackends 返回主存储和副本存储的名称
+func (c *Config) StorageBackends() []string {
+	return uniqueValues(c.StorageType(), c.Storage.Replicas)
+}
+
+// UsesBackend 判断配置中是否使用了指定的存储后端
+func (c *Config) UsesBackend(name string) bool {
+	for _, b := range c.StorageBackends() {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TelegramTokens 返回去重后的所有 bot token，主 token 在前
+func (c *Config) TelegramTokens() []string {
+	return uniqueValues(c.Telegram.Token, c.Telegram.Tokens)
+}
+
+// TelegramChatIDs 返回去重后的所有频道，主频道在前
+func (c *Config) TelegramChatIDs() []int64 {
+	return uniqueValues(c.Telegram.ChatID, c.Telegram.ChatIDs)
+}
+
+func uniqueValues[T comparable](first T, rest []T) []T {
+	var zero T
+	var values []T
+	seen := make(map[T]bool)
+	for _, v := range append([]T{first}, rest...) {
+		if v == zero || seen[v] {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values
+}
+
+// DefaultThumbnail 未配置时使用的 thumb 预设
+var DefaultThumbnail = ImagePreset{Width: 240, Height: 240, Fit: "cover", Format: "jpeg", Quality: 80}
+
+// ImagePresets 返回所有允许的缩放预设，未配置 thumb 时使用默认缩略图参数
+func (c *Config) ImagePresets() map[string]ImagePreset {
+	presets := map[string]ImagePreset{"thumb": DefaultThumbnail}
+	for name, p := range c.Image.Presets {
+		presets[name] = p
+	}
+	return presets
+}
+
+// ThumbnailPresets 返回上传时生成的缩略图预设名称，忽略未定义的预设
+func (c *Config) ThumbnailPresets() []string {
+	if len(c.Image.Thumbnails) == 0 {
+		return []string{"thumb"}
+	}
+	presets := c.ImagePresets()
+	var names []string
+	for _, name := range c.Image.Thumbnails {
+		if _, ok := presets[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// AllowedTypes 返回允许上传的 MIME 类型
+func (c *Config) AllowedTypes() []string {
+	if len(c.Image.AllowedTypes) == 0 {
+		return DefaultAllowedTypes
+	}
+	return c.Image.AllowedTypes
+}
+
+// AllowsType 判断是否允许上传指定类型的文件
+func (c *Config) AllowsType(mimeType string) bool {
+	for _, t := range c.AllowedTypes() {
+		if t == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsFile 判断通用文件模式下是否允许上传指定类型和扩展名的文件
+func (c *Config) AllowsFile(mimeType, ext string) bool {
+	// 图片只由 image.allowedTypes 控制，不允许的图片类型不能绕过校验和清理按普通文件保存
+	if !c.Files.Enabled || strings.HasPrefix(mimeType, "image/") {
+		return false
+	}
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			p = strings.ToLower(p)
+			if p == mimeType || p == ext ||
+				strings.HasSuffix(p, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		}
+		return false
+	}
+	if match(c.Files.Deny) {
+		return false
+	}
+	return len(c.Files.Allow) == 0 || match(c.Files.Allow)
+}
+
+// MaxFilesPerUpload 返回一次请求最多上传的文件数
+func (c *Config) MaxFilesPerUpload() int {
+	if c.Site.MaxFiles > 0 {
+		return c.Site.MaxFiles
+	}
+	return DefaultMaxFiles
+}
+
+// ImageMaxPixels 返回图片允许的最大像素数
+func (c *Config) ImageMaxPixels() int64 {
+	if c.Image.MaxPixels > 0 {
+		return c.Image.MaxPixels
+	}
+	return DefaultMaxPixels
+}
+
+// SimilarityThreshold 返回判断相似图片的汉明距离阈值
+func (c *Config) SimilarityThreshold() int {
+	if c.Image.Similarity > 0 {
+		return min(c.Image.Similarity, MaxSimilarity)
+	}
+	return DefaultSimilarity
+}
+
+// BlockThreshold 返回拒绝与封禁图片相似的上传时使用的汉明距离阈值
+func (c *Config) BlockThreshold() int {
+	if c.Image.BlockDistance > 0 {
+		return min(c.Image.BlockDistance, 32)
+	}
+	return c.SimilarityThreshold()
+}
+
+// KeptMetadata 返回移除元数据时保留的类型，未配置时只保留 ICC 色彩配置
+func (c *Config) KeptMetadata() []string {
+	if c.Image.KeepMetadata == nil {
+		return []string{"icc"}
+	}
+	return c.Image.KeepMetadata
+}
+
+// ImagePreset 图片缩放参数
+type ImagePreset struct {
+	Width   int    `json:"w"`
+	Height  int    `json:"h"`
+	Fit     string `json:"fit"` // contain（默认）、cover 或 fill
+	Format  string `json:"fmt"` // 输出格式，默认与原图相同
+	Quality int    `json:"q"`   // 输出质量 1-100，仅 JPEG 和 WebP 有效，默认 85
+}
+
 // ImageRecord 图片记录结构
 type ImageRecord struct {
 	ID          int
@@ -100,6 +348,10 @@ type ImageRecord struct {
 	ContentType string
 	IsActive    bool
 	ViewCount   int
+	Storage     string
+	FileID      string
+	BotID       int64
+	ChatID      int64
 }
 
 // FileURLCache 用于缓存文件URL
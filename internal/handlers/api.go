@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/utils"
+)
+
+// apiKeyPrefix API 密钥的固定前缀，便于识别泄露的密钥
+const apiKeyPrefix = "gi_"
+
+// APIFile 上传接口返回的文件信息
+type APIFile struct {
+	ID          int64  `json:"id"`
+	URL         string `json:"url"`
+	DeleteURL   string `json:"delete_url"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// APIError 接口错误响应中的 error 字段
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIKey 管理页面显示的密钥信息
+type APIKey struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	CreatedAt  string
+	LastUsedAt string
+	Revoked    bool
+	Uploads    int
+}
+
+// apiErrorCodes HTTP 状态码对应的错误代码
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusRequestEntityTooLarge: "file_too_large",
+	http.StatusUnprocessableEntity:   "fetch_failed",
+	http.StatusBadGateway:            "storage_error",
+	http.StatusServiceUnavailable:    "server_busy",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError 输出结构化的错误响应
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error *APIError `json:"error"`
+	}{newAPIError(status, message)})
+}
+
+func newAPIError(status int, message string) *APIError {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = "internal_error"
+	}
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// handleAPIError 与 handleError 相同，但输出 JSON
+func handleAPIError(w http.ResponseWriter, err *AppError) {
+	log.Printf("Error: %v", err.Error)
+	writeAPIError(w, err.Code, err.Message)
+}
+
+// authenticateAPIKey 校验请求携带的 API 密钥，返回密钥 ID，支持 Authorization: Bearer 和 X-API-Key 请求头
+func authenticateAPIKey(r *http.Request) (int64, error) {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			key = strings.TrimSpace(token)
+		}
+	}
+	if key == "" {
+		return 0, errors.New("missing API key")
+	}
+
+	var id int64
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		err := global.DB.QueryRowContext(ctx,
+			"SELECT id FROM api_keys WHERE key_hash = ? AND revoked = 0", hashToken(key),
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		_, err = global.DB.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("invalid API key")
+	}
+	return id, err
+}
+
+// HandleAPIUpload 通过 API 上传文件，表单字段为 file，可重复以一次上传多个文件
+func HandleAPIUpload(w http.ResponseWriter, r *http.Request) {
+	keyID, err := authenticateAPIKey(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	runUpload(w, writeAPIError, func(requestID string) {
+		from := newUploader(r)
+		from.apiKeyID = keyID
+		outcomes, appErr := receiveUploads(w, r, "file", requestID, from)
+		if appErr != nil {
+			appErr.Message = "Missing or unreadable form field \"file\""
+			handleAPIError(w, appErr)
+			return
+		}
+		writeAPIUploadResults(w, outcomes, baseURL(r))
+	})
+}
+
+// writeAPIUploadResults 输出上传结果，单个文件时直接返回文件信息或错误
+func writeAPIUploadResults(w http.ResponseWriter, outcomes []uploadOutcome, base string) {
+	// 单个文件保持原有的响应格式
+	if len(outcomes) == 1 {
+		if o := outcomes[0]; o.err != nil {
+			writeAPIError(w, o.err.Code, o.err.Message)
+		} else {
+			writeJSON(w, http.StatusCreated, newAPIFile(o.result, base))
+		}
+		return
+	}
+
+	// 多个文件逐个返回结果，失败的文件带有 error 字段
+	type apiFileResult struct {
+		*APIFile
+		Filename string    `json:"filename"`
+		Error    *APIError `json:"error,omitempty"`
+	}
+	files := make([]apiFileResult, 0, len(outcomes))
+	for _, o := range outcomes {
+		if o.err != nil {
+			files = append(files, apiFileResult{
+				Filename: utils.SanitizeFilename(o.filename),
+				Error:    newAPIError(o.err.Code, o.err.Message),
+			})
+			continue
+		}
+		files = append(files, apiFileResult{APIFile: newAPIFile(o.result, base), Filename: o.result.Filename})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Files []apiFileResult `json:"files"`
+	}{files})
+}
+
+// newAPIFile 将上传结果转换为接口响应
+func newAPIFile(result *uploadResult, base string) *APIFile {
+	return &APIFile{
+		ID:          result.ID,
+		URL:         result.URL,
+		DeleteURL:   base + "/api/v1/delete/" + result.DeleteToken,
+		Filename:    result.Filename,
+		ContentType: result.ContentType,
+		Size:        result.Size,
+		Width:       result.Width,
+		Height:      result.Height,
+	}
+}
+
+// HandleAPIDelete 使用上传时返回的删除地址永久删除文件
+func HandleAPIDelete(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	var imageID int64
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx,
+			"SELECT id FROM images WHERE delete_token = ?", hashToken(token),
+		).Scan(&imageID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		handleAPIError(w, &AppError{Error: err, Message: "Database error", Code: http.StatusInternalServerError})
+		return
+	}
+
+	result := purgeImage(r.Context(), imageID)
+	if !result.Deleted {
+		handleAPIError(w, &AppError{
+			Error:   errors.New(strings.Join(result.Errors, "; ")),
+			Message: "Failed to delete file, please try again later",
+			Code:    http.StatusBadGateway,
+		})
+		return
+	}
+	log.Printf("Image %d deleted by its uploader", imageID)
+	writeJSON(w, http.StatusOK, struct {
+		ID      int64 `json:"id"`
+		Deleted bool  `json:"deleted"`
+	}{imageID, true})
+}
+
+// newAPIKey 生成新的 API 密钥
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HandleAdminKeys 显示 API 密钥列表，POST 时创建新密钥并只显示一次
+func HandleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	var newKey string
+	if r.Method == http.MethodPost {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		key, err := newAPIKey()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			_, err := global.DB.ExecContext(ctx,
+				"INSERT INTO api_keys (name, key_hash, prefix) VALUES (?, ?, ?)",
+				name, hashToken(key), key[:len(apiKeyPrefix)+6])
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newKey = key
+	}
+
+	var keys []APIKey
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		rows, err := global.DB.QueryContext(ctx, `
+			SELECT k.id, k.name, k.prefix, k.created_at, COALESCE(strftime('%Y-%m-%dT%H:%M:%SZ', k.last_used_at), ''), k.revoked,
+				(SELECT COUNT(*) FROM images i WHERE i.api_key_id = k.id)
+			FROM api_keys k
+			ORDER BY k.id DESC`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var k APIKey
+			if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.Revoked, &k.Uploads); err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("templates/keys.tmpl")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title   string
+		Favicon string
+		Keys    []APIKey
+		NewKey  string
+		BaseURL string
+	}{
+		Title:   utils.GetPageTitle("API 密钥"),
+		Favicon: global.AppConfig.Site.Favicon,
+		Keys:    keys,
+		NewKey:  newKey,
+		BaseURL: baseURL(r),
+	}
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleRevokeKey 吊销 API 密钥，已上传的文件不受影响
+func HandleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx, "UPDATE api_keys SET revoked = 1 WHERE id = ?", id)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
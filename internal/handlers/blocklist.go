@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/utils"
+)
+
+// BlockedHash 封禁列表中的一条记录
+type BlockedHash struct {
+	ID        int64
+	PHash     string   // 没有感知哈希时为空
+	Checksums []string // 封禁文件的 SHA-256
+	ImageID   int64
+	Reason    string
+	CreatedAt string
+	Rejected  int // 因匹配该记录被拒绝的上传次数
+}
+
+// BlockedUpload 被拒绝的上传记录
+type BlockedUpload struct {
+	BlockedID int64
+	Distance  int
+	IPAddress string
+	UserAgent string
+	Filename  string
+	CreatedAt string
+}
+
+// sha256Prefix 没有感知哈希的封禁记录在 phash 列中保存的前缀，phash 列要求唯一
+const sha256Prefix = "sha256:"
+
+// matchBlocked 查找与上传文件相同或感知哈希相近的封禁记录，返回距离最小的一条，没有时 id 为 0。
+// SHA-256 完全相同时距离为 0，无法解码的图片也能拦截
+func matchBlocked(phash, sha string) (int64, int, error) {
+	var matchID int64
+	if sha != "" {
+		err := db.WithDBTimeout(func(ctx context.Context) error {
+			return global.DB.QueryRowContext(ctx,
+				"SELECT blocked_id FROM blocked_checksums WHERE sha256 = ?", sha,
+			).Scan(&matchID)
+		})
+		if err == nil {
+			return matchID, 0, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, err
+		}
+	}
+
+	hash, ok := imaging.ParseHash(phash)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	threshold := global.AppConfig.BlockThreshold()
+	matchDistance := threshold + 1
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		rows, err := global.DB.QueryContext(ctx, "SELECT id, phash FROM blocked_hashes")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			var s string
+			if err := rows.Scan(&id, &s); err != nil {
+				return err
+			}
+			blocked, ok := imaging.ParseHash(s)
+			if !ok {
+				continue
+			}
+			if d := imaging.Distance(hash, blocked); d < matchDistance {
+				matchID, matchDistance = id, d
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil || matchID == 0 {
+		return 0, 0, err
+	}
+	return matchID, matchDistance, nil
+}
+
+// recordBlockedUpload 记录被拒绝的上传
+func recordBlockedUpload(blockedID int64, phash string, distance int, ipAddress, userAgent, filename string) {
+	log.Printf("Rejected upload %q from %s: matches blocked hash %d (distance %d)", filename, ipAddress, blockedID, distance)
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx, `
+			INSERT INTO blocked_uploads (blocked_id, phash, distance, ip_address, user_agent, filename)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			blockedID, phash, distance, ipAddress, userAgent, filename)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to record blocked upload: %v", err)
+	}
+}
+
+// HandleBanImage 将图片标记为违规内容：将其感知哈希和 SHA-256 加入封禁列表，
+// 并禁用内容相同、共用存储对象或感知哈希相近的所有图片
+func HandleBanImage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	file := storedFile{}
+	var phash, sha string
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT id, storage, file_id, content_type, file_size, phash, sha256
+			FROM images
+			WHERE id = ?`, id,
+		).Scan(&file.imageID, &file.storage, &file.fileID, &file.contentType, &file.size, &phash, &sha)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 回填尚未完成时当场计算
+	if phash == "" {
+		phash, err = computePerceptualHash(r.Context(), file)
+		if err != nil {
+			handleError(w, &AppError{
+				Error:   err,
+				Message: "Failed to read image",
+				Code:    http.StatusBadGateway,
+			})
+			return
+		}
+	}
+	hash, hasPHash := imaging.ParseHash(phash)
+	if !hasPHash && sha == "" {
+		http.Error(w, "Neither perceptual hash nor checksum is available for this file", http.StatusBadRequest)
+		return
+	}
+	blockedPHash := phash
+	if !hasPHash {
+		blockedPHash = sha256Prefix + sha
+	}
+	threshold := global.AppConfig.BlockThreshold()
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	var disabled []int64
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		tx, err := global.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// 感知哈希已封禁时沿用原记录，SHA-256 单独记录，完全相同的文件同样拦截
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO blocked_hashes (phash, image_id, reason) VALUES (?, ?, ?)
+			ON CONFLICT(phash) DO NOTHING`,
+			blockedPHash, file.imageID, reason); err != nil {
+			return err
+		}
+		if sha != "" {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO blocked_checksums (sha256, blocked_id)
+				SELECT ?, id FROM blocked_hashes WHERE phash = ?
+				ON CONFLICT(sha256) DO NOTHING`,
+				sha, blockedPHash); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE images SET phash = ? WHERE id = ?", phash, file.imageID); err != nil {
+			return err
+		}
+
+		// 禁用内容相同、共用存储对象或感知哈希相近的所有图片
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, sha256, storage, file_id, phash FROM images WHERE is_active = 1`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var rowSHA, rowStorage, rowFileID, rowPHash string
+			if err := rows.Scan(&id, &rowSHA, &rowStorage, &rowFileID, &rowPHash); err != nil {
+				rows.Close()
+				return err
+			}
+			match := id == file.imageID ||
+				sha != "" && rowSHA == sha ||
+				rowStorage == file.storage && rowFileID == file.fileID
+			if other, ok := imaging.ParseHash(rowPHash); !match && ok && hasPHash {
+				match = imaging.Distance(hash, other) <= threshold
+			}
+			if match {
+				disabled = append(disabled, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range disabled {
+			if _, err := tx.ExecContext(ctx, "UPDATE images SET is_active = 0 WHERE id = ?", id); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Banned image %d (phash %s), disabled %d images", file.imageID, phash, len(disabled))
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleUnblock 从封禁列表中移除一条记录，不会恢复已禁用的图片
+func HandleUnblock(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		tx, err := global.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM blocked_checksums WHERE blocked_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM blocked_hashes WHERE id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleAdminBlocked 显示封禁列表和最近被拒绝的上传
+func HandleAdminBlocked(w http.ResponseWriter, r *http.Request) {
+	var blocked []BlockedHash
+	var uploads []BlockedUpload
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		rows, err := global.DB.QueryContext(ctx, `
+			SELECT b.id, b.phash, b.image_id, b.reason, b.created_at,
+				(SELECT COALESCE(GROUP_CONCAT(c.sha256, ' '), '') FROM blocked_checksums c WHERE c.blocked_id = b.id),
+				(SELECT COUNT(*) FROM blocked_uploads u WHERE u.blocked_id = b.id)
+			FROM blocked_hashes b
+			ORDER BY b.id DESC`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var b BlockedHash
+			var checksums string
+			if err := rows.Scan(&b.ID, &b.PHash, &b.ImageID, &b.Reason, &b.CreatedAt, &checksums, &b.Rejected); err != nil {
+				return err
+			}
+			b.Checksums = strings.Fields(checksums)
+			if strings.HasPrefix(b.PHash, sha256Prefix) {
+				b.PHash = ""
+			}
+			blocked = append(blocked, b)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = global.DB.QueryContext(ctx, `
+			SELECT blocked_id, distance, ip_address, user_agent, filename, created_at
+			FROM blocked_uploads
+			ORDER BY id DESC
+			LIMIT 100`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u BlockedUpload
+			if err := rows.Scan(&u.BlockedID, &u.Distance, &u.IPAddress, &u.UserAgent, &u.Filename, &u.CreatedAt); err != nil {
+				return err
+			}
+			uploads = append(uploads, u)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("templates/blocked.tmpl")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Favicon  string
+		Blocked  []BlockedHash
+		Uploads  []BlockedUpload
+		Distance int
+	}{
+		Title:    utils.GetPageTitle("封禁列表"),
+		Favicon:  global.AppConfig.Site.Favicon,
+		Blocked:  blocked,
+		Uploads:  uploads,
+		Distance: global.AppConfig.BlockThreshold(),
+	}
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/storage"
+)
+
+// findDuplicate 查找主存储中内容相同的有效文件，返回其记录 ID 和存储对象，没有时返回 nil
+func findDuplicate(hash, storageName, contentType string) (int64, *storage.Object, error) {
+	var imageID int64
+	obj := &storage.Object{ContentType: contentType}
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT id, file_id, telegram_url, bot_id, chat_id, message_id, file_size
+			FROM images
+			WHERE sha256 = ? AND storage = ? AND content_type = ? AND is_active = 1
+			ORDER BY id
+			LIMIT 1`,
+			hash, storageName, contentType,
+		).Scan(&imageID, &obj.Key, &obj.URL, &obj.BotID, &obj.ChatID, &obj.MessageID, &obj.Size)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return imageID, obj, nil
+}
+
+// copyDerived 让重复上传的新记录复用原记录的副本和派生图片
+func copyDerived(sourceID, imageID int64) {
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		if _, err := global.DB.ExecContext(ctx, `
+			INSERT OR IGNORE INTO image_replicas (image_id, storage, file_id, chat_id, message_id, status, error, updated_at)
+			SELECT ?, storage, file_id, chat_id, message_id, status, error, CURRENT_TIMESTAMP
+			FROM image_replicas WHERE image_id = ?`,
+			imageID, sourceID); err != nil {
+			return err
+		}
+		_, err := global.DB.ExecContext(ctx, `
+			INSERT OR IGNORE INTO image_variants (image_id, variant, storage, file_id, chat_id, message_id, content_type, file_size, width, height)
+			SELECT ?, variant, storage, file_id, chat_id, message_id, content_type, file_size, width, height
+			FROM image_variants WHERE image_id = ?`,
+			imageID, sourceID)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to copy replicas and variants of image %d to %d: %v", sourceID, imageID, err)
+	}
+}
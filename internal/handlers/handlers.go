@@ -2,21 +2,24 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
+	"hosting/internal/cache"
 	"hosting/internal/db"
 	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/storage"
 	"hosting/internal/utils"
 )
 
@@ -36,194 +39,109 @@ func handleError(w http.ResponseWriter, err *AppError) {
 // handleHome 使用 templates/home.html
 func HandleHome(w http.ResponseWriter, r *http.Request) {
 	t := template.Must(template.ParseFiles("templates/home.tmpl"))
+	// 前端根据浏览器识别的类型预先检查，浏览器对 ICO 使用另一个 MIME 类型。
+	// 允许上传其他文件时由服务器判断
+	var formats, allowedTypes []string
+	if !global.AppConfig.Files.Enabled {
+		allowedTypes = global.AppConfig.AllowedTypes()
+		for _, t := range allowedTypes {
+			if ext, ok := global.MimeTypeExtensions[t]; ok && !slices.Contains(formats, strings.ToUpper(ext[1:])) {
+				formats = append(formats, strings.ToUpper(ext[1:]))
+			}
+		}
+		if slices.Contains(allowedTypes, "image/x-icon") {
+			allowedTypes = append(slices.Clone(allowedTypes), "image/vnd.microsoft.icon")
+		}
+	}
+
 	data := struct {
-		Title       string
-		Favicon     string
-		MaxFileSize int
+		Title          string
+		Favicon        string
+		MaxFileSize    int
+		MaxFiles       int
+		AllowedTypes   []string
+		AllowedFormats string
 	}{
-		Title:       utils.GetPageTitle("图床"),
-		Favicon:     global.AppConfig.Site.Favicon,
-		MaxFileSize: global.AppConfig.Site.MaxFileSize,
+		Title:          utils.GetPageTitle("图床"),
+		Favicon:        global.AppConfig.Site.Favicon,
+		MaxFileSize:    global.AppConfig.Site.MaxFileSize,
+		MaxFiles:       global.AppConfig.MaxFilesPerUpload(),
+		AllowedTypes:   allowedTypes,
+		AllowedFormats: strings.Join(formats, "、"),
 	}
 	t.Execute(w, data)
 }
 
 // HandleUpload 精简说明
 func HandleUpload(w http.ResponseWriter, r *http.Request) {
-	// 添加请求追踪ID
-	requestID := uuid.New().String()
-	type contextKey string
-	const requestIDKey contextKey = "requestID"
-	ctx := context.WithValue(r.Context(), requestIDKey, requestID)
-
-	// 使用defer统一处理panic
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("[%s] Panic recovered: %v", requestID, err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-	}()
-
-	// 使用context控制超时
-	ctx, cancel := context.WithTimeout(ctx, global.UploadTimeout)
-	defer cancel()
-	r = r.WithContext(ctx)
-
-	// 并发控制使用channel代替mutex
-	select {
-	case global.UploadSemaphore <- struct{}{}:
-		defer func() { <-global.UploadSemaphore }()
-	default:
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
-		return
-	}
-
-	maxSize := int64(global.AppConfig.Site.MaxFileSize * 1024 * 1024)
-	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
-
-	file, header, err := r.FormFile("image")
-	if err != nil {
-		handleError(w, &AppError{
-			Error:   err,
-			Message: "无法读取上传文件",
-			Code:    http.StatusBadRequest,
-		})
-		return
-	}
-	defer file.Close()
-
-	if header.Size > maxSize {
-		http.Error(w, "File size exceeds limit", http.StatusBadRequest)
-		return
-	}
-
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	file.Seek(0, 0)
-
-	contentType := http.DetectContentType(buffer)
-	fileExt, ok := utils.GetFileExtension(contentType)
-	if !ok {
-		originalExt := utils.NormalizeFileExtension(header.Filename)
-		for mime, ext := range global.AllowedMimeTypes {
-			if ext == originalExt {
-				fileExt = ext
-				contentType = mime
-				ok = true
-				break
-			}
-		}
-
-		if !ok {
-			http.Error(w, "Unsupported file type. Only JPG/JPEG, PNG, GIF and WebP are allowed", http.StatusBadRequest)
+	// 超时由 receiveUploads 对每个文件单独控制
+	runUpload(w, plainError, func(requestID string) {
+		outcomes, appErr := receiveUploads(w, r, "image", requestID, newUploader(r))
+		if appErr != nil {
+			handleError(w, appErr)
 			return
 		}
-	}
-
-	ipAddress := utils.ValidateIPAddress(r.RemoteAddr)
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ipAddress = utils.ValidateIPAddress(forwardedFor)
-	}
-	userAgent := utils.SanitizeUserAgent(r.Header.Get("User-Agent"))
-	filename := utils.SanitizeFilename(header.Filename)
-
-	tempFile, err := os.CreateTemp("", "upload-*"+fileExt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	msg := tgbotapi.NewDocument(global.AppConfig.Telegram.ChatID, tgbotapi.FilePath(tempFile.Name()))
-	message, err := global.Bot.Send(msg)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		renderUploadResults(w, outcomes)
+	})
+}
 
-	fileID := message.Document.FileID
-	telegramURL, err := global.Bot.GetFileDirectURL(fileID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// renderUploadResults 显示上传结果页面
+func renderUploadResults(w http.ResponseWriter, outcomes []uploadOutcome) {
+	// 只上传一个文件且失败时直接返回错误信息，由上传页面显示
+	if len(outcomes) == 1 && outcomes[0].err != nil {
+		http.Error(w, outcomes[0].err.Message, outcomes[0].err.Code)
 		return
 	}
 
-	proxyUUID := uuid.New().String()
-	proxyURL := fmt.Sprintf("/file/%s%s", proxyUUID, fileExt)
-
-	var scheme string
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	} else {
-		scheme = "http"
-	}
-	fullURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, proxyURL)
-
-	err = db.WithDBTimeout(func(ctx context.Context) error {
-		stmt, err := global.DB.PrepareContext(ctx, `
-			INSERT INTO images (
-				telegram_url, 
-				proxy_url, 
-				ip_address, 
-				user_agent, 
-				filename,
-				content_type,
-				file_id
-			) VALUES (?, ?, ?, ?, ?, ?, ?)
-		`)
-		if err != nil {
-			return err
+	type uploadedFile struct {
+		Filename string
+		URL      string
+		Markdown string
+		IsImage  bool
+		Error    string
+	}
+	var files []uploadedFile
+	var urls, markdowns []string
+	for _, o := range outcomes {
+		if o.err != nil {
+			files = append(files, uploadedFile{Filename: utils.SanitizeFilename(o.filename), Error: o.err.Message})
+			continue
 		}
-		defer stmt.Close()
-
-		_, err = stmt.ExecContext(ctx,
-			telegramURL,
-			proxyURL,
-			ipAddress,
-			userAgent,
-			filename,
-			contentType,
-			fileID, // 添加 fileID
-		)
-		return err
-	})
-
-	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Printf("Error executing statement: %v", err)
-		return
+		markdown := fmt.Sprintf("[%s](%s)", o.result.Filename, o.result.URL)
+		if o.result.IsImage {
+			markdown = "!" + markdown
+		}
+		files = append(files, uploadedFile{
+			Filename: o.result.Filename,
+			URL:      o.result.URL,
+			Markdown: markdown,
+			IsImage:  o.result.IsImage,
+		})
+		urls = append(urls, o.result.URL)
+		markdowns = append(markdowns, markdown)
 	}
 
 	t := template.Must(template.ParseFiles("templates/upload.tmpl"))
 	data := struct {
-		Title    string
-		Favicon  string
-		URL      string
-		Filename string
+		Title       string
+		Favicon     string
+		Files       []uploadedFile
+		Succeeded   int
+		Failed      int
+		AllURLs     string
+		AllMarkdown string
 	}{
-		Title:    utils.GetPageTitle("上传"),
-		Favicon:  global.AppConfig.Site.Favicon,
-		URL:      fullURL,
-		Filename: filename,
+		Title:       utils.GetPageTitle("上传"),
+		Favicon:     global.AppConfig.Site.Favicon,
+		Files:       files,
+		Succeeded:   len(urls),
+		Failed:      len(files) - len(urls),
+		AllURLs:     strings.Join(urls, "\n"),
+		AllMarkdown: strings.Join(markdowns, "\n"),
 	}
 	t.Execute(w, data)
 }
 
-func GetTelegramFileURL(fileID string) (string, error) {
-	return global.Bot.GetFileDirectURL(fileID)
-}
-
 func HandleImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	uuid := vars["uuid"]
@@ -231,17 +149,18 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
 	w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
 
-	var telegramURL, contentType string
+	var imageID, fileSize int64
+	var contentType, storageName, uploadTime, filename string
 	var isActive bool
 	var fileID string
 
 	err := db.WithDBTimeout(func(ctx context.Context) error {
 		return global.DB.QueryRowContext(ctx, `
-            SELECT telegram_url, content_type, is_active, file_id 
+            SELECT id, content_type, is_active, file_id, storage, upload_time, file_size, filename
             FROM images 
             WHERE proxy_url LIKE ?`,
 			fmt.Sprintf("/file/%s%%", uuid),
-		).Scan(&telegramURL, &contentType, &isActive, &fileID)
+		).Scan(&imageID, &contentType, &isActive, &fileID, &storageName, &uploadTime, &fileSize, &filename)
 	})
 
 	if err != nil {
@@ -254,55 +173,248 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查URL缓存
-	global.URLCacheMux.RLock()
-	cache, exists := global.URLCache[telegramURL]
-	global.URLCacheMux.RUnlock()
+	file := storedFile{
+		imageID:     imageID,
+		storage:     storageName,
+		fileID:      fileID,
+		contentType: contentType,
+		size:        fileSize,
+		modTime:     parseUploadTime(uploadTime),
+		filename:    filename,
+		// 不在允许列表中的图片可能是此前按普通文件保存的，没有经过校验和清理
+		attachment: strings.HasPrefix(contentType, "image/") && !global.AppConfig.AllowsType(contentType),
+	}
 
-	var currentURL string
-	if !exists || time.Now().After(cache.ExpiresAt) {
-		// 获取新的URL
-		newURL, err := GetTelegramFileURL(fileID)
+	// 带缩放参数时输出派生图片
+	if preset, ok, err := transformPreset(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok {
+		variant, err := getVariant(r.Context(), file, preset)
 		if err != nil {
-			http.Error(w, "Failed to refresh file URL", http.StatusInternalServerError)
+			code := http.StatusInternalServerError
+			if errors.Is(err, errNotTransformable) {
+				code = http.StatusBadRequest
+			} else if errors.Is(err, errVariantBusy) {
+				code = http.StatusServiceUnavailable
+			}
+			handleError(w, &AppError{
+				Error:   err,
+				Message: "Failed to transform image",
+				Code:    code,
+			})
 			return
 		}
-
-		// 更新缓存
-		global.URLCacheMux.Lock()
-		global.URLCache[telegramURL] = &global.FileURLCache{
-			URL:       newURL,
-			ExpiresAt: time.Now().Add(global.URLCacheTime),
+		file = *variant
+	} else if global.AppConfig.Image.Negotiate && (contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/gif") {
+		w.Header().Set("Vary", "Accept")
+		if variant := negotiateVariant(r.Context(), r.Header.Get("Accept"), file); variant != nil {
+			file = *variant
 		}
-		global.URLCacheMux.Unlock()
+	}
 
-		currentURL = newURL
+	serveFile(w, r, uuid, file)
+}
+
+// storedFile 存储后端中的一个文件，派生图片的 imageID 为 0，不从副本读取
+type storedFile struct {
+	imageID     int64
+	storage     string
+	fileID      string
+	contentType string
+	size        int64
+	modTime     time.Time
+	filename    string // 作为附件下载时使用的文件名
+	attachment  bool   // 即使类型可以直接显示也作为附件下载
+}
+
+// serveFile 输出存储中的文件，处理条件请求、范围请求和本地缓存
+func serveFile(w http.ResponseWriter, r *http.Request, uuid string, file storedFile) {
+	storageName, fileID, imageID, modTime := file.storage, file.fileID, file.imageID, file.modTime
+
+	etag := etagFor(storageName, fileID)
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	backend, err := storage.Lookup(storageName)
+	if err != nil {
+		log.Printf("Storage backend of image %d unavailable: %v", imageID, err)
+	}
 
-		// 更新数据库中的URL
-		_, err = global.DB.Exec("UPDATE images SET telegram_url = ? WHERE proxy_url LIKE ?",
-			newURL, fmt.Sprintf("/file/%s%%", uuid))
+	inline := inlineTypes[file.contentType] && !file.attachment
+	// SVG 和附件需要由本程序输出以附加 CSP 和 Content-Disposition
+	if redirector, ok := backend.(storage.Redirector); ok && inline && file.contentType != "image/svg+xml" {
+		location, err := redirector.RedirectURL(r.Context(), fileID)
 		if err != nil {
-			log.Printf("Failed to update telegram URL in database: %v", err)
+			log.Printf("Failed to build redirect URL: %v", err)
+		} else if location != "" {
+			countView(r, uuid)
+			// 预签名地址会过期，不能让客户端长期缓存跳转
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Del("Expires")
+			http.Redirect(w, r, location, http.StatusFound)
+			return
 		}
-	} else {
-		currentURL = cache.URL
 	}
 
-	_, err = global.DB.Exec("UPDATE images SET view_count = view_count + 1 WHERE proxy_url LIKE ?",
-		fmt.Sprintf("/file/%s%%", uuid))
-	if err != nil {
-		log.Printf("Failed to update view count: %v", err)
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if file.contentType == "image/svg+xml" {
+		w.Header().Set("Content-Security-Policy", imaging.SVGPolicy)
+	}
+	if !inline {
+		w.Header().Set("Content-Disposition", attachmentDisposition(file.filename))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// 读取后端和输出可能超过服务器的 WriteTimeout，按写入进度延长
+	w = newDeadlineWriter(w)
+
+	// 缓存命中时由 ServeContent 处理范围请求和 HEAD
+	cacheKey := storageName + ":" + fileID
+	if cache.Default != nil {
+		if f, ok := cache.Default.Open(cacheKey); ok {
+			defer f.Close()
+			countView(r, uuid)
+			http.ServeContent(w, r, "", modTime, f)
+			return
+		}
 	}
 
-	resp, err := http.Get(currentURL)
+	// 旧记录没有保存文件大小，范围请求时向后端查询
+	size := file.size
+	if size <= 0 {
+		size = -1
+		if backend != nil && r.Header.Get("Range") != "" {
+			if obj, err := backend.Stat(r.Context(), fileID); err == nil {
+				size = obj.Size
+			}
+		}
+	}
+
+	rng, ok := parseRange(r.Header.Get("Range"), size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if rng != nil && !ifRangeMatch(r, etag, modTime) {
+		rng = nil
+	}
+
+	if r.Method == http.MethodHead {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, err := openImage(r.Context(), backend, storageName, fileID, imageID, rng)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		handleError(w, &AppError{
+			Error:   err,
+			Message: "Failed to fetch file",
+			Code:    code,
+		})
+		return
+	}
+	defer body.Close()
+
+	countView(r, uuid)
+
+	if rng != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.start+rng.length-1, size))
+		w.Header().Set("Content-Length", strconv.FormatInt(rng.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, body)
+		return
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	// 边输出边写入缓存，完整输出后才加入缓存
+	var dst io.Writer = w
+	var fill *cache.Writer
+	if cache.Default != nil {
+		if fill, err = cache.Default.Create(cacheKey); err != nil {
+			log.Printf("Failed to create cache entry: %v", err)
+		} else {
+			dst = io.MultiWriter(w, fill)
+		}
+	}
+
+	_, err = io.Copy(dst, body)
+	if fill != nil {
+		if err != nil {
+			fill.Abort()
+		} else if err := fill.Commit(); err != nil {
+			log.Printf("Failed to commit cache entry: %v", err)
+		}
+	}
+}
+
+// openImage 从主存储读取文件，失败时尝试副本，rng 不为 nil 时只读取该范围
+func openImage(ctx context.Context, backend storage.Backend, storageName, fileID string, imageID int64, rng *byteRange) (io.ReadCloser, error) {
+	var err error
+	if backend != nil {
+		var body io.ReadCloser
+		if rng != nil {
+			body, err = storage.GetRange(ctx, backend, fileID, rng.start, rng.length)
+		} else {
+			body, _, err = backend.Get(ctx, fileID)
+		}
+		if err == nil {
+			return body, nil
+		}
+		log.Printf("Failed to fetch image %d from %s: %v", imageID, storageName, err)
+	}
+
+	// 派生图片没有副本
+	if imageID == 0 {
+		if err == nil {
+			err = storage.ErrNotFound
+		}
+		return nil, err
+	}
+
+	// 主存储不可用时尝试副本，没有可用副本时保留主存储的错误
+	primaryErr := err
+	body, err := fetchReplica(ctx, imageID, rng)
+	if errors.Is(err, storage.ErrNotFound) && primaryErr != nil {
+		err = primaryErr
+	}
+	return body, err
+}
+
+// countView 记录访问次数，播放器的后续范围请求不重复计数，uuid 为空时不计数
+func countView(r *http.Request, uuid string) {
+	if uuid == "" || r.Method != http.MethodGet {
+		return
+	}
+	if rng := r.Header.Get("Range"); rng != "" && !strings.HasPrefix(rng, "bytes=0-") {
 		return
 	}
-	defer resp.Body.Close()
+	incrementViewCount(uuid)
+}
 
-	w.Header().Set("Content-Type", contentType)
-	io.Copy(w, resp.Body)
+func incrementViewCount(uuid string) {
+	_, err := global.DB.Exec("UPDATE images SET view_count = view_count + 1 WHERE proxy_url LIKE ?",
+		fmt.Sprintf("/file/%s%%", uuid))
+	if err != nil {
+		log.Printf("Failed to update view count: %v", err)
+	}
 }
 
 // 登录页面使用 templates/login.html
@@ -446,6 +558,9 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		"subtract": func(a, b int) int {
 			return a - b
 		},
+		"mb": func(n int64) string {
+			return fmt.Sprintf("%.1f MB", float64(n)/1024/1024)
+		},
 	}
 
 	t := template.New("admin.tmpl").Funcs(funcMap)
@@ -455,6 +570,12 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var cacheStats *cache.Stats
+	if cache.Default != nil {
+		stats := cache.Default.Stats()
+		cacheStats = &stats
+	}
+
 	data := struct {
 		Title      string
 		Favicon    string
@@ -463,6 +584,7 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		TotalPages int
 		HasPrev    bool
 		HasNext    bool
+		CacheStats *cache.Stats
 	}{
 		Title:      utils.GetPageTitle("管理"),
 		Favicon:    global.AppConfig.Site.Favicon,
@@ -471,6 +593,7 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		TotalPages: totalPages,
 		HasPrev:    page > 1,
 		HasNext:    page < totalPages,
+		CacheStats: cacheStats,
 	}
 	err = t.Execute(w, data)
 	if err != nil {
@@ -479,6 +602,51 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleAdminThumbnail 输出管理页面使用的缩略图，旧记录在首次访问时生成
+func HandleAdminThumbnail(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	file := storedFile{}
+	var uploadTime string
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT id, content_type, file_id, storage, upload_time, file_size
+			FROM images
+			WHERE id = ?`, id,
+		).Scan(&file.imageID, &file.contentType, &file.fileID, &file.storage, &uploadTime, &file.size)
+	})
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	file.modTime = parseUploadTime(uploadTime)
+
+	names := global.AppConfig.ThumbnailPresets()
+	if len(names) == 0 {
+		http.Error(w, "Thumbnail not configured", http.StatusNotFound)
+		return
+	}
+	preset := normalizePreset(global.AppConfig.ImagePresets()[names[0]])
+	variant, err := getVariant(r.Context(), file, preset)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errNotTransformable) {
+			code = http.StatusNotFound
+		} else if errors.Is(err, errVariantBusy) {
+			code = http.StatusServiceUnavailable
+		}
+		handleError(w, &AppError{
+			Error:   err,
+			Message: "Failed to load thumbnail",
+			Code:    code,
+		})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	serveFile(w, r, "", *variant)
+}
+
 func HandleToggleStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"hosting/internal/global"
+)
+
+// errorWriter 按接口的格式输出错误，页面使用纯文本，API 使用 JSON
+type errorWriter func(w http.ResponseWriter, status int, message string)
+
+// plainError 输出纯文本错误，由上传页面显示
+func plainError(w http.ResponseWriter, status int, message string) {
+	http.Error(w, message, status)
+}
+
+// runUpload 处理一次上传请求：生成请求追踪ID、捕获 panic，并占用一个上传名额，服务器繁忙时返回 503。
+// 需要认证的接口应在调用前完成认证，未通过的请求不占用名额
+func runUpload(w http.ResponseWriter, writeError errorWriter, handle func(requestID string)) {
+	requestID := uuid.New().String()
+	defer recoverUpload(w, requestID, writeError)
+
+	release, ok := acquireUploadSlot()
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "Server is busy")
+		return
+	}
+	defer release()
+
+	handle(requestID)
+}
+
+// recoverUpload 捕获处理上传时的 panic 并返回 500，必须直接 defer 调用
+func recoverUpload(w http.ResponseWriter, requestID string, writeError errorWriter) {
+	if err := recover(); err != nil {
+		log.Printf("[%s] Panic recovered: %v", requestID, err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// acquireUploadSlot 占用一个上传名额，没有空闲名额时立即返回 false，成功时需调用 release 释放
+func acquireUploadSlot() (release func(), ok bool) {
+	select {
+	case global.UploadSemaphore <- struct{}{}:
+		return func() { <-global.UploadSemaphore }, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache 有容量上限的内存 LRU 缓存，条目可以设置有效期
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time // 零值表示不过期
+}
+
+func newLRUCache[V any](max int) *lruCache[V] {
+	return &lruCache[V]{max: max, ll: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get 返回未过期的条目
+func (c *lruCache[V]) get(key string) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.entries[key]
+	if el == nil {
+		return value, false
+	}
+	e := el.Value.(*lruEntry[V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.entries, key)
+		return value, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// put 记录条目，ttl 为 0 时不过期，超出容量时淘汰最久未使用的条目
+func (c *lruCache[V]) put(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &lruEntry[V]{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	if el := c.entries[key]; el != nil {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.entries, el.Value.(*lruEntry[V]).key)
+	}
+}
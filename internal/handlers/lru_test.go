@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[bool](2)
+	c.put("a", true, 0)
+	c.put("b", false, 0)
+	if animated, ok := c.get("a"); !ok || !animated {
+		t.Fatalf("a = %v, %v", animated, ok)
+	}
+	// b 最久未使用，被淘汰
+	c.put("c", false, 0)
+	if _, ok := c.get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a evicted")
+	}
+
+	c.put("failed", true, time.Millisecond)
+	if _, ok := c.get("failed"); !ok {
+		t.Fatal("failure not cached")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("failed"); ok {
+		t.Error("failure not expired")
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+)
+
+// errInvalidUpload 上传的文件内容无效
+var errInvalidUpload = errors.New("invalid upload")
+
+// processedUpload 处理后的上传文件
+type processedUpload struct {
+	size          int64
+	rewritten     bool        // 文件内容被改写
+	width, height int         // 校验时得到的尺寸，SVG 为 0
+	img           image.Image // 校验时解码的图片，用于感知哈希和缩略图，只校验结构的格式为 nil
+}
+
+// processUpload 在写入存储前校验并处理上传的临时文件，内容直接从文件中读取，不整体载入内存
+func processUpload(f *os.File, size int64, contentType string) (processedUpload, error) {
+	result := processedUpload{size: size}
+
+	// SVG 无法解码校验，清理后保存
+	if contentType == "image/svg+xml" {
+		n, err := rewriteFile(f, func(w io.Writer) error {
+			return imaging.SanitizeSVG(w, io.NewSectionReader(f, 0, size))
+		})
+		if err != nil {
+			return result, err
+		}
+		result.size, result.rewritten = n, true
+		return result, nil
+	}
+
+	limits := imaging.Limits{
+		MaxWidth:  global.AppConfig.Image.MaxWidth,
+		MaxHeight: global.AppConfig.Image.MaxHeight,
+		MaxPixels: global.AppConfig.ImageMaxPixels(),
+	}
+	v, err := imaging.Validate(f, size, contentType, limits)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", errInvalidUpload, err)
+	}
+	result.width, result.height, result.img = v.Width, v.Height, v.Image
+
+	// 截掉图片结尾之后拼接的内容，如 Motion Photo 附加的视频、MPO 的其他帧
+	if v.End < size {
+		if err := f.Truncate(v.End); err != nil {
+			return result, err
+		}
+		result.size, result.rewritten = v.End, true
+	}
+
+	if global.AppConfig.Image.StripMetadata {
+		stripped, err := imaging.StripMetadata(f, result.size, contentType, global.AppConfig.KeptMetadata(), v.Image)
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", errInvalidUpload, err)
+		}
+		if stripped != nil {
+			n, err := rewriteFile(f, func(w io.Writer) error {
+				_, err := stripped.WriteTo(w)
+				return err
+			})
+			if err != nil {
+				return result, err
+			}
+			result.size, result.rewritten = n, true
+			if stripped.Image != nil {
+				result.img = stripped.Image
+			}
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// rewriteFile 用 write 生成的内容替换文件内容并返回新的长度。
+// 内容先写入另一个临时文件，生成时仍可以读取 f；write 返回的错误视为内容无效
+func rewriteFile(f *os.File, write func(w io.Writer) error) (int64, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := &stagingWriter{f: tmp}
+	if err := write(w); err != nil {
+		if w.err != nil {
+			return 0, w.err
+		}
+		return 0, fmt.Errorf("%w: %w", errInvalidUpload, err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, tmp)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return n, err
+}
+
+// stagingWriter 记录写入临时文件时的错误，以便与内容本身的错误区分
+type stagingWriter struct {
+	f   *os.File
+	err error
+}
+
+func (w *stagingWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hosting/internal/cache"
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/storage"
+)
+
+// PurgeResult 单个文件的永久删除结果
+type PurgeResult struct {
+	ID      int64    `json:"id"`
+	Deleted bool     `json:"deleted"`          // 记录已删除
+	Kept    []string `json:"kept,omitempty"`   // 仍被其他记录引用而保留的对象
+	Errors  []string `json:"errors,omitempty"` // 删除失败的对象，记录保留为禁用状态，可重试
+}
+
+// purgeTarget 永久删除时需要删除的一个存储对象
+type purgeTarget struct {
+	label   string // original、replica、variant 加上名称，用于报告
+	storage string
+	obj     storage.Object
+}
+
+// purgeImage 删除文件在所有存储中的内容和本地缓存，全部成功后删除记录。
+// 去重后共享的对象在仍被其他记录引用时保留。
+func purgeImage(ctx context.Context, imageID int64) PurgeResult {
+	result := PurgeResult{ID: imageID}
+
+	// 先禁用，删除失败时也不再对外提供访问
+	var targets []purgeTarget
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		res, err := global.DB.ExecContext(ctx, "UPDATE images SET is_active = 0 WHERE id = ?", imageID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+
+		t := purgeTarget{label: "original"}
+		if err := global.DB.QueryRowContext(ctx, `
+			SELECT storage, file_id, bot_id, chat_id, message_id FROM images WHERE id = ?`, imageID,
+		).Scan(&t.storage, &t.obj.Key, &t.obj.BotID, &t.obj.ChatID, &t.obj.MessageID); err != nil {
+			return err
+		}
+		targets = append(targets, t)
+
+		rows, err := global.DB.QueryContext(ctx, `
+			SELECT 'replica ' || storage, storage, file_id, chat_id, message_id
+			FROM image_replicas WHERE image_id = ? AND file_id != ''
+			UNION ALL
+			SELECT 'variant ' || variant, storage, file_id, chat_id, message_id
+			FROM image_variants WHERE image_id = ?`,
+			imageID, imageID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t purgeTarget
+			if err := rows.Scan(&t.label, &t.storage, &t.obj.Key, &t.obj.ChatID, &t.obj.MessageID); err != nil {
+				return err
+			}
+			targets = append(targets, t)
+		}
+		return rows.Err()
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		result.Errors = append(result.Errors, "image not found")
+		return result
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	for _, t := range targets {
+		shared, err := objectShared(imageID, t.storage, t.obj.Key)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", t.label, err))
+			continue
+		}
+		if shared {
+			result.Kept = append(result.Kept, t.label)
+			continue
+		}
+
+		if cache.Default != nil {
+			cache.Default.Remove(t.storage + ":" + t.obj.Key)
+		}
+		backend, err := storage.Lookup(t.storage)
+		if err == nil {
+			err = storage.DeleteObject(ctx, backend, &t.obj)
+		}
+		if errors.Is(err, storage.ErrNotSupported) {
+			err = errors.New("message id not recorded, delete it from the channel manually")
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", t.label, err))
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result
+	}
+
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		tx, err := global.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, query := range []string{
+			"DELETE FROM image_variants WHERE image_id = ?",
+			"DELETE FROM image_replicas WHERE image_id = ?",
+			"DELETE FROM images WHERE id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, imageID); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	result.Deleted = true
+	return result
+}
+
+// objectShared 判断存储对象是否还被其他记录引用
+func objectShared(imageID int64, storageName, fileID string) (bool, error) {
+	var shared bool
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM images WHERE storage = ? AND file_id = ? AND id != ?)
+				OR EXISTS (SELECT 1 FROM image_replicas WHERE storage = ? AND file_id = ? AND image_id != ?)
+				OR EXISTS (SELECT 1 FROM image_variants WHERE storage = ? AND file_id = ? AND image_id != ?)`,
+			storageName, fileID, imageID,
+			storageName, fileID, imageID,
+			storageName, fileID, imageID,
+		).Scan(&shared)
+	})
+	return shared, err
+}
+
+// HandlePurge 永久删除选中的文件，返回每个文件的处理结果
+func HandlePurge(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	for _, s := range strings.Split(r.FormValue("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid image id", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	results := make([]PurgeResult, 0, len(ids))
+	for _, id := range ids {
+		result := purgeImage(r.Context(), id)
+		if result.Deleted {
+			log.Printf("Purged image %d", id)
+		} else {
+			log.Printf("Failed to purge image %d: %s", id, strings.Join(result.Errors, "; "))
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"hosting/internal/global"
+)
+
+// maxRemoteRedirects 远程上传最多跟随的重定向次数
+const maxRemoteRedirects = 5
+
+var (
+	errForbiddenAddress = errors.New("remote address is not allowed")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// forbiddenPrefixes net/netip 没有单独判断的保留地址段
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // 运营商 NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// 内嵌 IPv4 地址的 IPv6 地址段，可能经过网关转发到内网
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"), // 本地 NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+	netip.MustParsePrefix("2002::/16"),      // 6to4
+}
+
+// remoteClient 下载远程文件使用的客户端，连接时检查解析后的地址，防止通过域名或重定向访问内网
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		// 不使用环境变量中的代理，否则检查的是代理地址
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkRemoteAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRemoteRedirects {
+			return errTooManyRedirects
+		}
+		return checkRemoteURL(req.URL)
+	},
+}
+
+// allowedRemoteAddr 判断是否允许连接该地址，只允许公网地址
+func allowedRemoteAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRemoteAddress 在建立连接前检查实际连接的地址，DNS 解析到内网地址时同样拒绝
+func checkRemoteAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !allowedRemoteAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// checkRemoteURL 检查远程地址的协议和主机，主机为 IP 时直接检查
+func checkRemoteURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("missing host")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !allowedRemoteAddr(addr) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, addr)
+	}
+	return nil
+}
+
+// remoteFilename 获取远程文件的文件名，优先使用 Content-Disposition
+func remoteFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "/" && name != "." {
+		return name
+	}
+	return ""
+}
+
+// fetchRemote 下载远程地址的文件并保存，与上传的文件使用相同的大小限制、类型检查和超时
+func fetchRemote(w http.ResponseWriter, r *http.Request, rawURL, requestID string, from uploader) uploadOutcome {
+	outcome := uploadOutcome{filename: rawURL}
+	outcome.result, outcome.err = downloadRemote(w, r, strings.TrimSpace(rawURL), requestID, from)
+	if outcome.err != nil {
+		log.Printf("Error: %v", outcome.err.Error)
+	}
+	return outcome
+}
+
+func downloadRemote(w http.ResponseWriter, r *http.Request, rawURL, requestID string, from uploader) (*uploadResult, *AppError) {
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		err = checkRemoteURL(u)
+	}
+	if err != nil {
+		appErr := &AppError{Error: fmt.Errorf("[%s] remote url %q: %w", requestID, rawURL, err), Message: "Invalid URL", Code: http.StatusBadRequest}
+		if errors.Is(err, errForbiddenAddress) {
+			appErr.Message = "URL points to a forbidden address"
+		}
+		return nil, appErr
+	}
+
+	// 下载和保存共用上传超时，服务器写超时随之延长
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(global.UploadTimeout))
+	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, &AppError{Error: err, Message: "Invalid URL", Code: http.StatusBadRequest}
+	}
+	req.Header.Set("User-Agent", "goImage")
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		appErr := &AppError{
+			Error:   fmt.Errorf("[%s] fetch %s: %w", requestID, u.Redacted(), err),
+			Message: "Failed to download remote file",
+			Code:    http.StatusUnprocessableEntity,
+		}
+		if errors.Is(err, errForbiddenAddress) {
+			appErr.Message, appErr.Code = "URL points to a forbidden address", http.StatusBadRequest
+		} else if errors.Is(err, errTooManyRedirects) {
+			appErr.Message = "Too many redirects"
+		}
+		return nil, appErr
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] fetch %s: status %s", requestID, u.Redacted(), resp.Status),
+			Message: fmt.Sprintf("Remote server returned %s", resp.Status),
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	maxSize := int64(global.AppConfig.Site.MaxFileSize * 1024 * 1024)
+	if resp.ContentLength > maxSize {
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] remote file too large: %d bytes", requestID, resp.ContentLength),
+			Message: "File size exceeds limit",
+			Code:    http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	log.Printf("[%s] Downloading %s", requestID, u.Redacted())
+	return saveUpload(ctx, requestID, http.MaxBytesReader(nil, resp.Body, maxSize), remoteFilename(resp), baseURL(r), from)
+}
+
+// HandleRemoteUpload 下载表单字段 url 指定的远程文件并保存，结果与上传页面相同
+func HandleRemoteUpload(w http.ResponseWriter, r *http.Request) {
+	runUpload(w, plainError, func(requestID string) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		renderUploadResults(w, []uploadOutcome{fetchRemote(w, r, r.FormValue("url"), requestID, newUploader(r))})
+	})
+}
+
+// HandleAPIRemoteUpload 通过 API 上传远程文件，表单字段为 url
+func HandleAPIRemoteUpload(w http.ResponseWriter, r *http.Request) {
+	keyID, err := authenticateAPIKey(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	runUpload(w, writeAPIError, func(requestID string) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		from := newUploader(r)
+		from.apiKeyID = keyID
+		writeAPIUploadResults(w, []uploadOutcome{fetchRemote(w, r, r.FormValue("url"), requestID, from)}, baseURL(r))
+	})
+}
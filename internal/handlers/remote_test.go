@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAllowedRemoteAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"2a00:1450:4001:82b::200e", true},
+
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // 云服务元数据地址
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false}, // IPv4 映射地址
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:8.8.8.8", true},
+		{"64:ff9b::a00:1", false},      // NAT64 10.0.0.1
+		{"64:ff9b::808:808", false},    // NAT64 整段拒绝
+		{"64:ff9b:1::7f00:1", false},   // 本地 NAT64
+		{"2002:7f00:1::", false},       // 6to4 127.0.0.1
+		{"2001:0:4136:e378::1", false}, // Teredo
+	}
+	for _, tt := range tests {
+		if got := allowedRemoteAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("allowedRemoteAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if allowedRemoteAddr(netip.Addr{}) {
+		t.Error("zero address allowed")
+	}
+}
+
+func TestCheckRemoteAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:80", true},
+		{"127.0.0.1:80", false},
+		{"[::ffff:169.254.169.254]:80", false},
+		{"[fe80::1%eth0]:80", false},
+		{"localhost:80", false},
+	}
+	for _, tt := range tests {
+		if err := checkRemoteAddress("tcp", tt.address, nil); (err == nil) != tt.ok {
+			t.Errorf("checkRemoteAddress(%s) = %v, want ok %v", tt.address, err, tt.ok)
+		}
+	}
+}
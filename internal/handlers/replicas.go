@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/storage"
+)
+
+// storeReplicas 将上传的文件写入所有副本后端并记录状态，副本失败不影响上传结果
+func storeReplicas(ctx context.Context, imageID int64, file io.ReaderAt, size int64, filename, contentType string) {
+	for _, backend := range storage.Replicas {
+		status, errMsg := "ok", ""
+		obj, err := backend.Put(ctx, filename, io.NewSectionReader(file, 0, size), size, contentType)
+		if err != nil {
+			log.Printf("Failed to store replica of image %d in %s: %v", imageID, backend.Name(), err)
+			status, errMsg = "failed", err.Error()
+			obj = &storage.Object{}
+		}
+
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			_, err := global.DB.ExecContext(ctx, `
+				INSERT OR REPLACE INTO image_replicas (image_id, storage, file_id, chat_id, message_id, status, error, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+				imageID, backend.Name(), obj.Key, obj.ChatID, obj.MessageID, status, errMsg)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to record replica of image %d in %s: %v", imageID, backend.Name(), err)
+		}
+	}
+}
+
+// fetchReplica 主存储读取失败时依次尝试可用的副本，rng 不为 nil 时只读取该范围
+func fetchReplica(ctx context.Context, imageID int64, rng *byteRange) (io.ReadCloser, error) {
+	type replica struct {
+		storage string
+		fileID  string
+	}
+	var replicas []replica
+
+	err := db.WithDBTimeout(func(dbCtx context.Context) error {
+		rows, err := global.DB.QueryContext(dbCtx, `
+			SELECT storage, file_id FROM image_replicas
+			WHERE image_id = ? AND status = 'ok'`, imageID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r replica
+			if err := rows.Scan(&r.storage, &r.fileID); err != nil {
+				return err
+			}
+			replicas = append(replicas, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := storage.ErrNotFound
+	for _, r := range replicas {
+		backend, err := storage.Lookup(r.storage)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var body io.ReadCloser
+		if rng != nil {
+			body, err = storage.GetRange(ctx, backend, r.fileID, rng.start, rng.length)
+		} else {
+			body, _, err = backend.Get(ctx, r.fileID)
+		}
+		if err != nil {
+			log.Printf("Failed to fetch replica of image %d from %s: %v", imageID, r.storage, err)
+			if !errors.Is(err, storage.ErrNotFound) {
+				lastErr = err
+			}
+			continue
+		}
+		return body, nil
+	}
+	return nil, lastErr
+}
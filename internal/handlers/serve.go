@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange 单个字节范围 [start, start+length)
+type byteRange struct {
+	start, length int64
+}
+
+// inlineTypes 可以在浏览器中直接显示的类型，其他类型一律作为附件下载
+var inlineTypes = map[string]bool{
+	"image/jpeg":    true,
+	"image/jpg":     true,
+	"image/png":     true,
+	"image/gif":     true,
+	"image/webp":    true,
+	"image/avif":    true,
+	"image/heic":    true,
+	"image/heif":    true,
+	"image/bmp":     true,
+	"image/tiff":    true,
+	"image/x-icon":  true,
+	"image/svg+xml": true,
+	"video/mp4":     true,
+	"video/webm":    true,
+	"audio/mpeg":    true,
+	"audio/wave":    true,
+}
+
+// streamIdleTimeout 输出文件时两次写入之间允许的最长间隔。
+// 服务器的 WriteTimeout 按整个响应计算，大文件和视频流需要边输出边延长
+const streamIdleTimeout = 30 * time.Second
+
+// deadlineWriter 每次写入前延长写超时，只中断长时间没有进展的连接
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newDeadlineWriter(w http.ResponseWriter) *deadlineWriter {
+	d := &deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w)}
+	d.extend()
+	return d
+}
+
+func (d *deadlineWriter) extend() {
+	d.rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.extend()
+	return d.ResponseWriter.Write(p)
+}
+
+// Unwrap 供 http.ResponseController 获取底层连接
+func (d *deadlineWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// attachmentDisposition 生成附件下载的 Content-Disposition，非 ASCII 文件名按 RFC 2231 编码
+func attachmentDisposition(filename string) string {
+	if filename == "" {
+		return "attachment"
+	}
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return "attachment"
+}
+
+// etagFor 根据存储位置生成 ETag，同一存储对象的内容不会变化
+func etagFor(storageName, fileID string) string {
+	sum := sha256.Sum256([]byte(storageName + ":" + fileID))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// parseUploadTime 解析数据库中的上传时间
+func parseUploadTime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// notModified 判断条件请求是否可以返回 304
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatch(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagListMatch 弱比较 If-None-Match 中的 ETag 列表
+func etagListMatch(list, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatch 判断 If-Range 是否允许返回部分内容
+func ifRangeMatch(r *http.Request, etag string, modTime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// parseRange 解析单个字节范围，格式无效或包含多个范围时返回 nil 以输出完整内容。
+// 格式正确但范围无法满足时 ok 为 false。
+func parseRange(header string, size int64) (rng *byteRange, ok bool) {
+	if header == "" || size < 0 {
+		return nil, true
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return nil, true
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return nil, true
+	}
+
+	if startStr == "" {
+		// bytes=-N 表示最后 N 个字节
+		n, err := parseRangeInt(endStr)
+		if err != nil {
+			return nil, true
+		}
+		if n == 0 {
+			return nil, false
+		}
+		n = min(n, size)
+		return &byteRange{start: size - n, length: n}, true
+	}
+
+	start, err := parseRangeInt(startStr)
+	if err != nil {
+		return nil, true
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = parseRangeInt(endStr)
+		if err != nil || end < start {
+			return nil, true
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, false
+	}
+	return &byteRange{start: start, length: end - start + 1}, true
+}
+
+// parseRangeInt 解析范围中的位置，只接受十进制数字，不允许符号
+func parseRangeInt(s string) (int64, error) {
+	n, err := strconv.ParseUint(s, 10, 63)
+	return int64(n), err
+}
+
+// acceptsType 判断 Accept 请求头是否明确接受指定的 MIME 类型，不考虑通配符
+func acceptsType(accept, mimeType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), mimeType) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, err := strconv.ParseFloat(v, 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
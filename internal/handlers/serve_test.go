@@ -0,0 +1,48 @@
+package handlers
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		want   *byteRange // nil 表示输出完整内容
+		ok     bool
+	}{
+		{"", 100, nil, true},
+		{"bytes=0-9", 100, &byteRange{0, 10}, true},
+		{"bytes=10-", 100, &byteRange{10, 90}, true},
+		{"bytes=99-", 100, &byteRange{99, 1}, true},
+		{"bytes=90-200", 100, &byteRange{90, 10}, true}, // 结束位置超出时截断
+		{"bytes=-10", 100, &byteRange{90, 10}, true},
+		{"bytes=-100", 100, &byteRange{0, 100}, true},
+		{"bytes=-200", 100, &byteRange{0, 100}, true}, // 后缀长度超出时返回全部
+		{"bytes= 5-6", 100, &byteRange{5, 2}, true},
+
+		// 无法满足
+		{"bytes=100-", 100, nil, false},
+		{"bytes=100-200", 100, nil, false},
+		{"bytes=-0", 100, nil, false},
+		{"bytes=0-0", 0, nil, false},
+
+		// 无效或不支持时忽略，输出完整内容
+		{"bytes=0-1,5-6", 100, nil, true},
+		{"items=0-9", 100, nil, true},
+		{"bytes=9-0", 100, nil, true},
+		{"bytes=a-9", 100, nil, true},
+		{"bytes=0-b", 100, nil, true},
+		{"bytes=-x", 100, nil, true},
+		{"bytes=--5", 100, nil, true},
+		{"bytes=-+5", 100, nil, true},
+		{"bytes=+5-9", 100, nil, true},
+		{"bytes=200-100", 100, nil, true}, // 结束位置小于开始位置时无效
+		{"bytes=5", 100, nil, true},
+		{"bytes=0-9", -1, nil, true}, // 长度未知
+	}
+	for _, tt := range tests {
+		got, ok := parseRange(tt.header, tt.size)
+		if ok != tt.ok || (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("parseRange(%q, %d) = %+v, %v; want %+v, %v", tt.header, tt.size, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/utils"
+)
+
+// noPerceptualHash 标记无法计算感知哈希的记录，回填时跳过
+const noPerceptualHash = "-"
+
+// decodeUpload 解码上传的图片，格式不支持解码或解码失败时返回 nil
+func decodeUpload(file io.ReaderAt, size int64, contentType string) image.Image {
+	if imaging.FormatOf(contentType) == "" {
+		return nil
+	}
+	img, _, err := imaging.Decode(io.NewSectionReader(file, 0, size), global.AppConfig.ImageMaxPixels())
+	if err != nil {
+		log.Printf("Failed to decode %s image: %v", contentType, err)
+		return nil
+	}
+	return img
+}
+
+// perceptualHash 返回保存到数据库的感知哈希
+func perceptualHash(img image.Image) string {
+	if img == nil {
+		return noPerceptualHash
+	}
+	return imaging.FormatHash(imaging.DHash(img))
+}
+
+// computePerceptualHash 读取已保存的图片并计算感知哈希
+func computePerceptualHash(ctx context.Context, f storedFile) (string, error) {
+	if imaging.FormatOf(f.contentType) == "" {
+		return noPerceptualHash, nil
+	}
+	var buf bytes.Buffer
+	if err := readOriginal(ctx, f, &buf); err != nil {
+		return "", err
+	}
+	data := buf.Bytes()
+	return perceptualHash(decodeUpload(bytes.NewReader(data), int64(len(data)), f.contentType)), nil
+}
+
+// BackfillPerceptualHashes 为旧记录补充感知哈希，启动后在后台运行
+func BackfillPerceptualHashes() {
+	const batchSize = 50
+	total := 0
+	var lastID int64
+	for {
+		var files []storedFile
+		err := db.WithDBTimeout(func(ctx context.Context) error {
+			rows, err := global.DB.QueryContext(ctx, `
+				SELECT id, storage, file_id, content_type, file_size
+				FROM images
+				WHERE phash = '' AND id > ?
+				ORDER BY id
+				LIMIT ?`, lastID, batchSize)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var f storedFile
+				if err := rows.Scan(&f.imageID, &f.storage, &f.fileID, &f.contentType, &f.size); err != nil {
+					return err
+				}
+				files = append(files, f)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			log.Printf("Failed to query images for perceptual hash backfill: %v", err)
+			return
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, f := range files {
+			lastID = f.imageID
+			ctx, cancel := context.WithTimeout(context.Background(), global.UploadTimeout)
+			hash, err := computePerceptualHash(ctx, f)
+			cancel()
+			if err != nil {
+				// 无法读取的记录标记为没有感知哈希，避免每次启动都卡在同一条记录上
+				log.Printf("Failed to read image %d for perceptual hash: %v", f.imageID, err)
+				hash = noPerceptualHash
+			}
+
+			err = db.WithDBTimeout(func(ctx context.Context) error {
+				_, err := global.DB.ExecContext(ctx, "UPDATE images SET phash = ? WHERE id = ?", hash, f.imageID)
+				return err
+			})
+			if err != nil {
+				log.Printf("Failed to save perceptual hash of image %d: %v", f.imageID, err)
+				return
+			}
+			total++
+		}
+	}
+	if total > 0 {
+		log.Printf("Computed perceptual hashes for %d images", total)
+	}
+}
+
+// SimilarImage 相似图片分组中的一条记录
+type SimilarImage struct {
+	ID         int64
+	ProxyURL   string
+	Filename   string
+	UploadTime string
+	ViewCount  int
+	FileSize   int64
+	Distance   int // 与分组中第一张图片的汉明距离
+	hash       uint64
+}
+
+// findSimilarGroups 将有效图片按感知哈希聚类，返回包含两张以上图片的分组，组内按 ID 排序
+func findSimilarGroups(ctx context.Context, threshold int) ([][]SimilarImage, error) {
+	rows, err := global.DB.QueryContext(ctx, `
+		SELECT id, proxy_url, filename, upload_time, view_count, file_size, phash
+		FROM images
+		WHERE is_active = 1 AND length(phash) = 16
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []SimilarImage
+	for rows.Next() {
+		var img SimilarImage
+		var phash string
+		if err := rows.Scan(&img.ID, &img.ProxyURL, &img.Filename, &img.UploadTime,
+			&img.ViewCount, &img.FileSize, &phash); err != nil {
+			return nil, err
+		}
+		hash, ok := imaging.ParseHash(phash)
+		if !ok {
+			continue
+		}
+		img.hash = hash
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 并查集合并距离在阈值内的图片
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	// 距离不超过 threshold 的两个哈希分成至少 threshold+1 段后必有一段完全相同，
+	// 只比较有相同分段的图片，避免两两比较
+	width := 64 / (threshold + 1)
+	for s := 0; s*width < 64; s++ {
+		shift := s * width
+		mask := uint64(1)<<min(width, 64-shift) - 1
+		buckets := make(map[uint64][]int)
+		for i, img := range images {
+			key := img.hash >> shift & mask
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, members := range buckets {
+			for a := 0; a < len(members); a++ {
+				for b := a + 1; b < len(members); b++ {
+					i, j := members[a], members[b]
+					if find(i) != find(j) && imaging.Distance(images[i].hash, images[j].hash) <= threshold {
+						parent[find(i)] = find(j)
+					}
+				}
+			}
+		}
+	}
+
+	grouped := make(map[int][]SimilarImage)
+	for i := range images {
+		root := find(i)
+		grouped[root] = append(grouped[root], images[i])
+	}
+
+	var groups [][]SimilarImage
+	for _, group := range grouped {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(a, b int) bool { return group[a].ID < group[b].ID })
+		for i := range group {
+			group[i].Distance = imaging.Distance(group[0].hash, group[i].hash)
+		}
+		groups = append(groups, group)
+	}
+	// 图片多的分组排在前面
+	sort.Slice(groups, func(a, b int) bool {
+		if len(groups[a]) != len(groups[b]) {
+			return len(groups[a]) > len(groups[b])
+		}
+		return groups[a][0].ID < groups[b][0].ID
+	})
+	return groups, nil
+}
+
+// HandleAdminSimilar 显示相似图片分组
+func HandleAdminSimilar(w http.ResponseWriter, r *http.Request) {
+	threshold := global.AppConfig.SimilarityThreshold()
+	if d, err := strconv.Atoi(r.URL.Query().Get("distance")); err == nil && d >= 0 && d <= global.MaxSimilarity {
+		threshold = d
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*global.DBTimeout)
+	defer cancel()
+	groups, err := findSimilarGroups(ctx, threshold)
+	if err != nil {
+		handleError(w, &AppError{
+			Error:   err,
+			Message: "Failed to load similar images",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	var pending int
+	db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM images WHERE phash = ''").Scan(&pending)
+	})
+
+	funcMap := template.FuncMap{
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"kb": func(n int64) string {
+			return strconv.FormatFloat(float64(n)/1024, 'f', 1, 64) + " KB"
+		},
+	}
+	t, err := template.New("similar.tmpl").Funcs(funcMap).ParseFiles("templates/similar.tmpl")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Favicon  string
+		Groups   [][]SimilarImage
+		Distance int
+		Pending  int
+	}{
+		Title:    utils.GetPageTitle("相似图片"),
+		Favicon:  global.AppConfig.Site.Favicon,
+		Groups:   groups,
+		Distance: threshold,
+		Pending:  pending,
+	}
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleKeepSimilar 保留分组中的一张图片，禁用其余图片
+func HandleKeepSimilar(w http.ResponseWriter, r *http.Request) {
+	keep, err := strconv.ParseInt(r.FormValue("keep"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid image id", http.StatusBadRequest)
+		return
+	}
+
+	var ids []any
+	var placeholders []string
+	for _, s := range strings.Split(r.FormValue("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid image id", http.StatusBadRequest)
+			return
+		}
+		if id != keep {
+			ids = append(ids, id)
+			placeholders = append(placeholders, "?")
+		}
+	}
+	if len(ids) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx,
+			"UPDATE images SET is_active = 0 WHERE id IN ("+strings.Join(placeholders, ",")+")", ids...)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Disabled %d similar images, kept image %d", len(ids), keep)
+	w.WriteHeader(http.StatusOK)
+}
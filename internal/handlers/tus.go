@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"hosting/internal/global"
+	"hosting/internal/resumable"
+)
+
+// tusVersion 支持的 tus 协议版本，见 https://tus.io/protocols/resumable-upload
+const tusVersion = "1.0.0"
+
+// tusError 输出 tus 请求的错误，响应中同样需要带有协议版本
+func tusError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	http.Error(w, message, status)
+}
+
+// checkTusVersion 检查客户端使用的协议版本
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		tusError(w, http.StatusPreconditionFailed, "Unsupported tus version")
+		return false
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+	return true
+}
+
+// parseTusMetadata 解析 Upload-Metadata 请求头，格式为逗号分隔的 "键 base64值"
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", key, err)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// tusFilename 返回客户端提交的文件名，tus 客户端通常使用 filename 或 name
+func tusFilename(u *resumable.Upload) string {
+	if name := u.Metadata["filename"]; name != "" {
+		return name
+	}
+	return u.Metadata["name"]
+}
+
+// HandleTusOptions 返回服务器支持的协议版本和扩展
+func HandleTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(global.AppConfig.Site.MaxFileSize*1024*1024))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTusCreate 创建断点续传上传，可选携带 API 密钥，与 API 上传的文件一样记录所属密钥
+func HandleTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		tusError(w, http.StatusBadRequest, "Upload-Defer-Length is not supported")
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		tusError(w, http.StatusBadRequest, "Invalid Upload-Length")
+		return
+	}
+	if length > int64(global.AppConfig.Site.MaxFileSize*1024*1024) {
+		tusError(w, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+		return
+	}
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		tusError(w, http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	from := newUploader(r)
+	if r.Header.Get("X-API-Key") != "" || r.Header.Get("Authorization") != "" {
+		from.apiKeyID, err = authenticateAPIKey(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			tusError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+
+	u := &resumable.Upload{
+		Length:    length,
+		Metadata:  meta,
+		IPAddress: from.ipAddress,
+		UserAgent: from.userAgent,
+		APIKeyID:  from.apiKeyID,
+	}
+	err = resumable.Default.Create(u)
+	if errors.Is(err, resumable.ErrFull) {
+		tusError(w, http.StatusServiceUnavailable, "Too many unfinished uploads, please try again later")
+		return
+	}
+	if errors.Is(err, resumable.ErrTooMany) {
+		tusError(w, http.StatusTooManyRequests, "Too many unfinished uploads from this address")
+		return
+	}
+	if err != nil {
+		handleError(w, &AppError{Error: err, Message: "Failed to create upload", Code: http.StatusInternalServerError})
+		return
+	}
+
+	w.Header().Set("Location", baseURL(r)+"/tus/"+u.ID)
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleTusHead 返回已接收的字节数，客户端据此继续上传
+func HandleTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	u, err := resumable.Default.Get(mux.Vars(r)["id"])
+	if errors.Is(err, resumable.ErrNotFound) {
+		tusError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if err != nil {
+		handleError(w, &AppError{Error: err, Message: "Failed to read upload", Code: http.StatusInternalServerError})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	if u.Result != nil {
+		w.Header().Set("X-File-URL", u.Result.URL)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleTusPatch 追加上传的内容，全部接收后按普通上传保存文件
+func HandleTusPatch(w http.ResponseWriter, r *http.Request) {
+	requestID := uuid.New().String()
+
+	defer recoverUpload(w, requestID, tusError)
+
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		tusError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		tusError(w, http.StatusBadRequest, "Invalid Upload-Offset")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	unlock, ok := resumable.Default.Lock(id)
+	if !ok {
+		tusError(w, http.StatusLocked, "Upload is in use by another request")
+		return
+	}
+	defer unlock()
+
+	u, err := resumable.Default.Get(id)
+	if errors.Is(err, resumable.ErrNotFound) {
+		tusError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if err != nil {
+		handleError(w, &AppError{Error: err, Message: "Failed to read upload", Code: http.StatusInternalServerError})
+		return
+	}
+	if offset != u.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		tusError(w, http.StatusConflict, "Upload-Offset does not match")
+		return
+	}
+	if u.Error != "" {
+		tusError(w, http.StatusBadRequest, u.Error)
+		return
+	}
+
+	if !u.Done() {
+		// 每个请求只需在上传超时内传完自己的部分
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Now().Add(global.UploadTimeout))
+		rc.SetWriteDeadline(time.Now().Add(global.UploadTimeout))
+
+		err := resumable.Default.Append(u, r.Body)
+		if errors.Is(err, resumable.ErrFull) {
+			// 容量已满，已写入的部分保留，客户端稍后从新的位置继续
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			tusError(w, http.StatusServiceUnavailable, "Upload storage is full, please try again later")
+			return
+		}
+		if err != nil {
+			// 已写入的部分保留，客户端通过 HEAD 获取新的位置后继续
+			log.Printf("[%s] Resumable upload %s interrupted at %d: %v", requestID, u.ID, u.Offset, err)
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			tusError(w, http.StatusInternalServerError, "Failed to store upload data")
+			return
+		}
+	}
+
+	if u.Offset == u.Length && !u.Done() {
+		result, appErr := finishResumable(r, requestID, u)
+		if appErr != nil {
+			handleError(w, appErr)
+			return
+		}
+		// 删除凭据只在完成时返回一次
+		if u.APIKeyID != 0 {
+			w.Header().Set("X-Delete-URL", baseURL(r)+"/api/v1/delete/"+result.DeleteToken)
+		}
+	}
+
+	if u.Result != nil {
+		w.Header().Set("X-File-URL", u.Result.URL)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishResumable 保存接收完成的文件。服务器繁忙或存储失败时保留内容，客户端可以再次发送空的 PATCH 重试；
+// 文件本身无效时记录原因并删除内容
+func finishResumable(r *http.Request, requestID string, u *resumable.Upload) (*uploadResult, *AppError) {
+	release, ok := acquireUploadSlot()
+	if !ok {
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] server busy finishing upload %s", requestID, u.ID),
+			Message: "Server is busy",
+			Code:    http.StatusServiceUnavailable,
+		}
+	}
+	defer release()
+
+	f, err := resumable.Default.Open(u)
+	if err != nil {
+		return nil, &AppError{Error: err, Message: "Failed to read upload", Code: http.StatusInternalServerError}
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+	defer cancel()
+
+	from := uploader{ipAddress: u.IPAddress, userAgent: u.UserAgent, apiKeyID: u.APIKeyID}
+	result, appErr := saveUpload(ctx, requestID, f, tusFilename(u), baseURL(r), from)
+	if appErr != nil && appErr.Code >= http.StatusInternalServerError {
+		return nil, appErr
+	}
+	if appErr != nil {
+		u.Error = appErr.Message
+	} else {
+		u.Result = &resumable.Result{
+			ImageID:  result.ID,
+			URL:      result.URL,
+			Filename: result.Filename,
+			IsImage:  result.IsImage,
+		}
+	}
+	if err := resumable.Default.Finish(u); err != nil {
+		log.Printf("[%s] Failed to finish resumable upload %s: %v", requestID, u.ID, err)
+	}
+	return result, appErr
+}
+
+// HandleTusDelete 取消上传并删除已接收的内容
+func HandleTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	unlock, ok := resumable.Default.Lock(id)
+	if !ok {
+		tusError(w, http.StatusLocked, "Upload is in use by another request")
+		return
+	}
+	defer unlock()
+
+	err := resumable.Default.Remove(id)
+	if errors.Is(err, resumable.ErrNotFound) {
+		tusError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if err != nil {
+		handleError(w, &AppError{Error: err, Message: "Failed to delete upload", Code: http.StatusInternalServerError})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResumableResult 显示断点续传上传的结果页面，ids 为逗号分隔的上传 ID
+func HandleResumableResult(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > global.AppConfig.MaxFilesPerUpload() {
+		http.Error(w, "Too many uploads", http.StatusBadRequest)
+		return
+	}
+
+	var outcomes []uploadOutcome
+	for _, id := range ids {
+		outcome := uploadOutcome{filename: id}
+		u, err := resumable.Default.Get(id)
+		switch {
+		case err != nil:
+			outcome.err = &AppError{Error: err, Message: "Upload not found or expired", Code: http.StatusNotFound}
+		case u.Result != nil:
+			outcome.result = &uploadResult{
+				ID:       u.Result.ImageID,
+				URL:      u.Result.URL,
+				Filename: u.Result.Filename,
+				IsImage:  u.Result.IsImage,
+			}
+		case u.Error != "":
+			outcome.err = &AppError{Error: errors.New(u.Error), Message: u.Error, Code: http.StatusBadRequest}
+		default:
+			outcome.err = &AppError{Error: errors.New("upload not completed"), Message: "Upload not completed", Code: http.StatusConflict}
+		}
+		if u != nil && tusFilename(u) != "" {
+			outcome.filename = tusFilename(u)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	renderUploadResults(w, outcomes)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/resumable"
+	"hosting/internal/storage"
+)
+
+// setupTus 使用临时目录中的数据库、本地存储和断点续传目录启动 tus 接口
+func setupTus(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	global.AppConfig = global.Config{}
+	global.AppConfig.Database.Path = filepath.Join(dir, "images.db")
+	global.AppConfig.Storage.Type = "local"
+	global.AppConfig.Storage.Local.Path = filepath.Join(dir, "files")
+	global.AppConfig.Site.MaxFileSize = 1
+	db.InitDB()
+	t.Cleanup(func() { global.DB.Close() })
+	storage.InitStorage()
+	global.UploadSemaphore = make(chan struct{}, 1)
+
+	var err error
+	resumable.Default, err = resumable.New(filepath.Join(dir, "uploads"), 1024*1024, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/tus/", HandleTusCreate).Methods("POST")
+	r.HandleFunc("/tus/{id}", HandleTusHead).Methods("HEAD")
+	r.HandleFunc("/tus/{id}", HandleTusPatch).Methods("PATCH")
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tusRequest(t *testing.T, method, url string, headers map[string]string, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func patch(t *testing.T, url string, offset int, chunk []byte) *http.Response {
+	t.Helper()
+	return tusRequest(t, http.MethodPatch, url, map[string]string{
+		"Upload-Offset": strconv.Itoa(offset),
+		"Content-Type":  "application/offset+octet-stream",
+	}, chunk)
+}
+
+func TestTusResumeUpload(t *testing.T) {
+	srv := setupTus(t)
+	data := testPNG(t)
+
+	resp := tusRequest(t, http.MethodPost, srv.URL+"/tus/", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("photo.png")),
+	}, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %s", resp.Status)
+	}
+	location := resp.Header.Get("Location")
+
+	// 第一段传到一半后中断
+	half := len(data) / 2
+	if resp := patch(t, location, 0, data[:half]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("first patch: %s", resp.Status)
+	}
+
+	// 断开后从服务器记录的位置继续
+	resp = tusRequest(t, http.MethodHead, location, nil, nil)
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(half) {
+		t.Fatalf("HEAD Upload-Offset = %s, want %d", got, half)
+	}
+	if resp := patch(t, location, 0, data); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("patch with stale offset: %s, want 409", resp.Status)
+	}
+
+	resp = patch(t, location, half, data[half:])
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("final patch: %s", resp.Status)
+	}
+	if resp.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) || resp.Header.Get("X-File-URL") == "" {
+		t.Fatalf("final patch headers: %v", resp.Header)
+	}
+
+	// 合并后的文件保存到本地存储，内容与原文件一致
+	var storageName, fileID, filename string
+	err := global.DB.QueryRow(`SELECT storage, file_id, filename FROM images`).Scan(&storageName, &fileID, &filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageName != "local" || filename != "photo.png" {
+		t.Errorf("stored as %s/%s", storageName, filename)
+	}
+	body, _, err := storage.Primary.Get(context.Background(), fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	stored, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("stored %d bytes, want the %d uploaded bytes", len(stored), len(data))
+	}
+
+	// 完成后重复的 PATCH 只返回结果
+	if resp := patch(t, location, len(data), nil); resp.StatusCode != http.StatusNoContent || resp.Header.Get("X-File-URL") == "" {
+		t.Errorf("patch after finish: %s", resp.Status)
+	}
+}
+
+func TestTusLimits(t *testing.T) {
+	srv := setupTus(t)
+	create := func(length int) *http.Response {
+		return tusRequest(t, http.MethodPost, srv.URL+"/tus/", map[string]string{"Upload-Length": strconv.Itoa(length)}, nil)
+	}
+
+	if resp := create(2 * 1024 * 1024); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized create: %s", resp.Status)
+	}
+
+	// 声明的长度不占用容量，同一地址最多 2 个未完成的上传
+	first := create(1024 * 1024)
+	second := create(1024 * 1024)
+	if first.StatusCode != http.StatusCreated || second.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %s, %s", first.Status, second.Status)
+	}
+	if resp := create(10); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third create: %s, want 429", resp.Status)
+	}
+
+	// 已接收的内容占满容量后拒绝继续写入，已写入的部分保留
+	chunk := make([]byte, 1024*1024)
+	if resp := patch(t, first.Header.Get("Location"), 0, chunk[:1000]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: %s", resp.Status)
+	}
+	resp := patch(t, second.Header.Get("Location"), 0, chunk)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("patch over capacity: %s, want 503", resp.Status)
+	}
+	if got, want := resp.Header.Get("Upload-Offset"), strconv.Itoa(len(chunk)-1000); got != want {
+		t.Errorf("Upload-Offset = %s, want %s", got, want)
+	}
+}
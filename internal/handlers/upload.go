@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/storage"
+	"hosting/internal/utils"
+)
+
+// uploader 上传来源
+type uploader struct {
+	ipAddress string
+	userAgent string
+	apiKeyID  int64 // 通过 API 上传时使用的密钥
+}
+
+// uploadResult 保存成功的上传
+type uploadResult struct {
+	ID          int64
+	URL         string // 完整访问地址
+	ProxyURL    string
+	Filename    string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	IsImage     bool
+	DeleteToken string // 删除凭据，只在上传时返回，数据库中保存其哈希
+}
+
+// uploadOutcome 批量上传中单个文件的结果，result 和 err 只有一个不为 nil
+type uploadOutcome struct {
+	filename string
+	result   *uploadResult
+	err      *AppError
+}
+
+// receiveUploads 按顺序读取表单中名为 field 的所有文件并逐个保存，单个文件失败不影响其他文件。
+// 没有读取到任何文件时返回错误。
+func receiveUploads(w http.ResponseWriter, r *http.Request, field, requestID string, from uploader) ([]uploadOutcome, *AppError) {
+	maxSize := int64(global.AppConfig.Site.MaxFileSize * 1024 * 1024)
+	maxFiles := global.AppConfig.MaxFilesPerUpload()
+	// 每个文件单独限制大小，请求总大小另外留出表单结构的余量
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize*int64(maxFiles)+1<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &AppError{Error: err, Message: "无法读取上传文件", Code: http.StatusBadRequest}
+	}
+
+	base := baseURL(r)
+	rc := http.NewResponseController(w)
+	var outcomes []uploadOutcome
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(outcomes) == 0 {
+				return nil, &AppError{Error: err, Message: "无法读取上传文件", Code: http.StatusBadRequest}
+			}
+			// 已保存的文件仍然返回结果
+			log.Printf("[%s] Failed to read next part: %v", requestID, err)
+			break
+		}
+		if part.FormName() != field || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		outcome := uploadOutcome{filename: part.FileName()}
+		if len(outcomes) >= maxFiles {
+			outcome.err = &AppError{
+				Error:   fmt.Errorf("[%s] too many files", requestID),
+				Message: fmt.Sprintf("Too many files, at most %d per upload", maxFiles),
+				Code:    http.StatusBadRequest,
+			}
+		} else {
+			// 每个文件单独计算超时，避免批量上传被整体超时中断
+			rc.SetReadDeadline(time.Now().Add(global.UploadTimeout))
+			rc.SetWriteDeadline(time.Now().Add(global.UploadTimeout))
+			ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+			outcome.result, outcome.err = saveUpload(ctx, requestID, http.MaxBytesReader(nil, part, maxSize), part.FileName(), base, from)
+			cancel()
+		}
+		part.Close()
+
+		if outcome.err != nil {
+			log.Printf("Error: %v", outcome.err.Error)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	if len(outcomes) == 0 {
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] no %q field in upload", requestID, field),
+			Message: "无法读取上传文件",
+			Code:    http.StatusBadRequest,
+		}
+	}
+	return outcomes, nil
+}
+
+// newUploader 根据请求获取上传来源
+func newUploader(r *http.Request) uploader {
+	return uploader{
+		ipAddress: utils.ClientIP(r),
+		userAgent: utils.SanitizeUserAgent(r.Header.Get("User-Agent")),
+	}
+}
+
+// baseURL 返回访问本站的地址前缀
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// saveUpload 校验、处理并保存一个上传的文件，调用方负责限制 src 的长度和并发
+func saveUpload(ctx context.Context, requestID string, src io.Reader, originalName, base string, from uploader) (*uploadResult, *AppError) {
+	filename := utils.SanitizeFilename(originalName)
+
+	tempFile, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, &AppError{Error: err, Message: "Failed to process upload", Code: http.StatusInternalServerError}
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	// 写入临时文件的同时计算哈希，用于去重
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hasher), src)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &AppError{Error: err, Message: "File size exceeds limit", Code: http.StatusRequestEntityTooLarge}
+		}
+		return nil, &AppError{Error: fmt.Errorf("[%s] read upload: %w", requestID, err), Message: "无法读取上传文件", Code: http.StatusBadRequest}
+	}
+
+	buffer := make([]byte, 512)
+	n, err := tempFile.ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return nil, &AppError{Error: err, Message: "Failed to process upload", Code: http.StatusInternalServerError}
+	}
+
+	// 只根据文件内容判断类型，之后还会完整解码校验
+	contentType := imaging.DetectContentType(buffer[:n])
+	fileExt, isImage := utils.GetFileExtension(contentType)
+	if !isImage {
+		// 通用文件模式下按允许列表接受其他类型
+		contentType, _, _ = mime.ParseMediaType(contentType)
+		fileExt = utils.GetGenericFileExtension(originalName, contentType)
+		if !global.AppConfig.AllowsFile(contentType, fileExt) {
+			return nil, &AppError{
+				Error:   fmt.Errorf("[%s] unsupported file type %s", requestID, contentType),
+				Message: "Unsupported file type",
+				Code:    http.StatusBadRequest,
+			}
+		}
+	}
+
+	var processed processedUpload
+	if isImage {
+		processed, err = processUpload(tempFile, size, contentType)
+	} else {
+		processed.size = size
+		_, err = tempFile.Seek(0, io.SeekStart)
+	}
+	if err == nil && processed.rewritten {
+		// 处理后的内容有变化，按实际保存的内容重新计算
+		hasher.Reset()
+		if _, err = io.Copy(hasher, io.NewSectionReader(tempFile, 0, processed.size)); err == nil {
+			_, err = tempFile.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		appErr := &AppError{
+			Error:   fmt.Errorf("[%s] process upload: %w", requestID, err),
+			Message: "Invalid image file",
+			Code:    http.StatusBadRequest,
+		}
+		if errors.Is(err, imaging.ErrTooLarge) {
+			appErr.Message = "Image dimensions exceed limit"
+		} else if !errors.Is(err, errInvalidUpload) {
+			appErr.Message, appErr.Code = "Failed to process upload", http.StatusInternalServerError
+		}
+		return nil, appErr
+	}
+	size = processed.size
+
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
+
+	// 复用校验时解码的图片计算感知哈希和生成缩略图
+	img := processed.img
+	phash := ""
+	width, height := processed.width, processed.height
+	if isImage {
+		phash = perceptualHash(img)
+		if img != nil {
+			width, height = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+	}
+
+	// 拒绝与封禁图片相似的上传
+	blockedID, distance, err := matchBlocked(phash, contentHash)
+	if err != nil {
+		// 无法确认时不保存，避免封禁的图片在数据库繁忙时被上传
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] check blocklist: %w", requestID, err),
+			Message: "Server is busy, please try again later",
+			Code:    http.StatusServiceUnavailable,
+		}
+	}
+	if blockedID != 0 {
+		recordBlockedUpload(blockedID, phash, distance, from.ipAddress, from.userAgent, filename)
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] upload matches blocked hash %d", requestID, blockedID),
+			Message: "This image matches content that has been banned and cannot be uploaded",
+			Code:    http.StatusForbidden,
+		}
+	}
+
+	// 已有相同内容的文件时复用其存储对象，不再重复上传
+	backend := storage.Primary
+	duplicateOf, obj, err := findDuplicate(contentHash, backend.Name(), contentType)
+	if err != nil {
+		log.Printf("[%s] Failed to look up duplicate: %v", requestID, err)
+	}
+	if obj == nil {
+		obj, err = backend.Put(ctx, filename, tempFile, size, contentType)
+		if err != nil {
+			return nil, &AppError{
+				Error:   fmt.Errorf("[%s] storage put: %w", requestID, err),
+				Message: global.ErrUploadFailed,
+				Code:    http.StatusBadGateway,
+			}
+		}
+	}
+
+	deleteToken, err := newDeleteToken()
+	if err != nil {
+		return nil, &AppError{Error: err, Message: "Failed to process upload", Code: http.StatusInternalServerError}
+	}
+
+	proxyURL := fmt.Sprintf("/file/%s%s", uuid.New().String(), fileExt)
+
+	var imageID int64
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		stmt, err := global.DB.PrepareContext(ctx, `
+			INSERT INTO images (
+				telegram_url,
+				proxy_url,
+				ip_address,
+				user_agent,
+				filename,
+				content_type,
+				file_id,
+				storage,
+				bot_id,
+				chat_id,
+				message_id,
+				file_size,
+				sha256,
+				phash,
+				api_key_id,
+				delete_token
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		result, err := stmt.ExecContext(ctx,
+			obj.URL,
+			proxyURL,
+			from.ipAddress,
+			from.userAgent,
+			filename,
+			contentType,
+			obj.Key,
+			backend.Name(),
+			obj.BotID,
+			obj.ChatID,
+			obj.MessageID,
+			size,
+			contentHash,
+			phash,
+			from.apiKeyID,
+			hashToken(deleteToken),
+		)
+		if err != nil {
+			return err
+		}
+		imageID, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, &AppError{
+			Error:   fmt.Errorf("[%s] insert image: %w", requestID, err),
+			Message: "Database error",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+
+	if duplicateOf != 0 {
+		copyDerived(duplicateOf, imageID)
+	} else {
+		storeReplicas(ctx, imageID, tempFile, size, filename, contentType)
+		storeThumbnails(ctx, imageID, img, contentType)
+	}
+
+	return &uploadResult{
+		ID:          imageID,
+		URL:         base + proxyURL,
+		ProxyURL:    proxyURL,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Width:       width,
+		Height:      height,
+		IsImage:     isImage,
+		DeleteToken: deleteToken,
+	}, nil
+}
+
+// newDeleteToken 生成随机的删除凭据
+func newDeleteToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken 返回凭据保存到数据库的哈希，凭据为高熵随机值，无需加盐
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"hosting/internal/cache"
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/storage"
+)
+
+var (
+	errNotTransformable = errors.New("image cannot be transformed")
+	errVariantBusy      = errors.New("too many images being transformed")
+)
+
+// transformParams 表示需要输出派生图片的查询参数
+var transformParams = []string{"w", "h", "fit", "fmt", "q", "preset"}
+
+// transformPreset 解析查询参数中的缩放参数，只允许配置中的预设，避免生成无限多的派生图片
+func transformPreset(query url.Values) (global.ImagePreset, bool, error) {
+	var p global.ImagePreset
+	if !slices.ContainsFunc(transformParams, query.Has) {
+		return p, false, nil
+	}
+
+	presets := global.AppConfig.ImagePresets()
+	if name := query.Get("preset"); name != "" {
+		preset, ok := presets[name]
+		if !ok {
+			return p, false, fmt.Errorf("unknown preset %q", name)
+		}
+		return normalizePreset(preset), true, nil
+	}
+
+	for _, param := range []struct {
+		key string
+		dst *int
+	}{{"w", &p.Width}, {"h", &p.Height}, {"q", &p.Quality}} {
+		if v := query.Get(param.key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return p, false, fmt.Errorf("invalid parameter %s", param.key)
+			}
+			*param.dst = n
+		}
+	}
+	p.Fit = query.Get("fit")
+	p.Format = query.Get("fmt")
+
+	p = normalizePreset(p)
+	for _, preset := range presets {
+		if normalizePreset(preset) == p {
+			return p, true, nil
+		}
+	}
+	return p, false, errors.New("transform parameters not allowed")
+}
+
+func normalizePreset(p global.ImagePreset) global.ImagePreset {
+	if p.Fit == "" {
+		p.Fit = "contain"
+	}
+	if p.Quality == 0 {
+		p.Quality = 85
+	}
+	p.Format = imaging.NormalizeFormat(p.Format)
+	return p
+}
+
+// variantKey 派生图片的唯一标识，JPEG 和 WebP 以外的输出忽略质量参数
+func variantKey(p global.ImagePreset, format string) string {
+	quality := 0
+	if format == "jpeg" || format == "webp" {
+		quality = p.Quality
+	}
+	return fmt.Sprintf("%dx%d-%s-q%d.%s", p.Width, p.Height, p.Fit, quality, format)
+}
+
+var (
+	variantMu    sync.Mutex
+	variantCalls = make(map[string]*variantCall)
+)
+
+// variantCall 正在生成的派生图片，完成后关闭 done
+type variantCall struct {
+	done    chan struct{}
+	imageID int64
+	result  *storedFile
+	err     error
+}
+
+// startVariant 登记正在生成的派生图片，已有相同的生成任务时返回该任务和 false
+func startVariant(key string, imageID int64) (*variantCall, bool) {
+	variantMu.Lock()
+	defer variantMu.Unlock()
+	if call := variantCalls[key]; call != nil {
+		return call, false
+	}
+	call := &variantCall{done: make(chan struct{}), imageID: imageID}
+	variantCalls[key] = call
+	return call, true
+}
+
+func finishVariant(key string, call *variantCall) {
+	variantMu.Lock()
+	delete(variantCalls, key)
+	variantMu.Unlock()
+	close(call.done)
+}
+
+// negotiatedFormats 按优先级排列的协商格式。
+// 没有纯 Go 的 AVIF 编码器，因此只输出 WebP
+var negotiatedFormats = []string{"webp"}
+
+// animatedImages 记录 GIF 原图是否为动图，键为存储后端和 file_id
+var animatedImages = newLRUCache[bool](10000)
+
+// failedVariants 记录无法由原图生成的派生图片，键与 variantCalls 相同，
+// 过期前直接返回错误，不再重复读取和解码原图
+var failedVariants = newLRUCache[error](10000)
+
+// negotiateVariant 客户端接受 WebP 时返回体积更小的转换版本，否则返回 nil
+func negotiateVariant(ctx context.Context, accept string, file storedFile) *storedFile {
+	for _, format := range negotiatedFormats {
+		contentType, _ := imaging.ContentType(format)
+		if !imaging.CanEncode(format) || !acceptsType(accept, contentType) {
+			continue
+		}
+		// 动图转换后只剩第一帧，使用原图
+		if isAnimated(ctx, file) {
+			return nil
+		}
+		// JPEG 原图有损转换，PNG/GIF 原图无损转换
+		p := global.ImagePreset{Format: format}
+		if file.contentType != "image/jpeg" {
+			p.Quality = 100
+		}
+		variant, err := getVariant(ctx, file, normalizePreset(p))
+		if err != nil {
+			log.Printf("Failed to convert image %d to %s: %v", file.imageID, format, err)
+			continue
+		}
+		// 转换后更大时仍使用原图，派生记录保留以免重复转换
+		if file.size > 0 && variant.size < file.size {
+			return variant
+		}
+	}
+	return nil
+}
+
+// isAnimated 判断原图是否为动图，结果按对象缓存，只需读取一次原图。
+// 读取失败时按动图处理，不转换，一分钟内不再重试
+func isAnimated(ctx context.Context, file storedFile) bool {
+	if file.contentType != "image/gif" {
+		return false
+	}
+	key := file.storage + ":" + file.fileID
+	if animated, ok := animatedImages.get(key); ok {
+		return animated
+	}
+	tmp, err := os.CreateTemp("", "animated-*")
+	if err != nil {
+		return true
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := readOriginal(ctx, file, tmp); err != nil {
+		log.Printf("Failed to read image %d: %v", file.imageID, err)
+		if ctx.Err() == nil {
+			animatedImages.put(key, true, time.Minute)
+		}
+		return true
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return true
+	}
+	animated := imaging.IsAnimated(tmp, size, file.contentType)
+	animatedImages.put(key, animated, 0)
+	return animated
+}
+
+// variantFormat 确定派生图片的输出格式和标识
+func variantFormat(contentType string, p global.ImagePreset) (format, key string, err error) {
+	srcFormat := imaging.FormatOf(contentType)
+	if srcFormat == "" {
+		return "", "", fmt.Errorf("%w: %s", errNotTransformable, contentType)
+	}
+	format = p.Format
+	if format == "" {
+		format = srcFormat
+	}
+	if !imaging.CanEncode(format) {
+		return "", "", fmt.Errorf("%w: unsupported format %s", errNotTransformable, format)
+	}
+	return format, variantKey(p, format), nil
+}
+
+// getVariant 返回图片的派生版本，不存在时由原图生成并保存到主存储
+func getVariant(ctx context.Context, file storedFile, p global.ImagePreset) (*storedFile, error) {
+	format, key, err := variantFormat(file.contentType, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同一原图的同一派生图片同时只生成一次，去重共享原图的记录复用生成的文件
+	flightKey := fmt.Sprintf("%s:%s/%s", file.storage, file.fileID, key)
+	for {
+		if v, err := lookupVariant(file.imageID, key); err != nil || v != nil {
+			return v, err
+		}
+		if err, ok := failedVariants.get(flightKey); ok {
+			return nil, err
+		}
+
+		call, leader := startVariant(flightKey, file.imageID)
+		if leader {
+			call.result, call.err = generateVariant(ctx, file, p, format, key)
+			if errors.Is(call.err, errNotTransformable) {
+				failedVariants.put(flightKey, call.err, 5*time.Minute)
+			}
+			finishVariant(flightKey, call)
+			return call.result, call.err
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.imageID == file.imageID || call.err != nil {
+			return call.result, call.err
+		}
+		if err := shareVariant(call.imageID, file.imageID, key); err != nil {
+			return nil, err
+		}
+		// 生成者的记录已被删除时重新查找或生成
+	}
+}
+
+// shareVariant 让共用原图的记录复用另一条记录已保存的派生图片
+func shareVariant(sourceID, imageID int64, key string) error {
+	return db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx, `
+			INSERT OR IGNORE INTO image_variants (image_id, variant, storage, file_id, chat_id, message_id, content_type, file_size, width, height)
+			SELECT ?, variant, storage, file_id, chat_id, message_id, content_type, file_size, width, height
+			FROM image_variants WHERE image_id = ? AND variant = ?`,
+			imageID, sourceID, key)
+		return err
+	})
+}
+
+// generateVariant 由原图生成派生图片，同时生成的数量受 VariantSemaphore 限制。
+// 客户端断开后仍继续生成，等待同一结果的其他请求不受影响。
+func generateVariant(ctx context.Context, file storedFile, p global.ImagePreset, format, key string) (*storedFile, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), global.UploadTimeout)
+	defer cancel()
+
+	select {
+	case global.VariantSemaphore <- struct{}{}:
+		defer func() { <-global.VariantSemaphore }()
+	case <-ctx.Done():
+		return nil, errVariantBusy
+	}
+
+	src, err := os.CreateTemp("", "variant-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+
+	if err := readOriginal(ctx, file, src); err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := imaging.Decode(src, global.AppConfig.ImageMaxPixels())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotTransformable, err)
+	}
+	return saveVariant(ctx, file.imageID, img, p, format, key)
+}
+
+func lookupVariant(imageID int64, key string) (*storedFile, error) {
+	v := storedFile{}
+	var createdAt string
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT storage, file_id, content_type, file_size, created_at
+			FROM image_variants
+			WHERE image_id = ? AND variant = ?`,
+			imageID, key,
+		).Scan(&v.storage, &v.fileID, &v.contentType, &v.size, &createdAt)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.modTime = parseUploadTime(createdAt)
+	return &v, nil
+}
+
+// storeThumbnails 上传时生成配置的缩略图，失败不影响上传结果
+func storeThumbnails(ctx context.Context, imageID int64, img image.Image, contentType string) {
+	if img == nil {
+		return
+	}
+
+	presets := global.AppConfig.ImagePresets()
+	for _, name := range global.AppConfig.ThumbnailPresets() {
+		p := normalizePreset(presets[name])
+		format, key, err := variantFormat(contentType, p)
+		if err == nil {
+			_, err = saveVariant(ctx, imageID, img, p, format, key)
+		}
+		if err != nil {
+			log.Printf("Failed to create thumbnail %s of image %d: %v", name, imageID, err)
+		}
+	}
+}
+
+// saveVariant 缩放并编码图片，保存到主存储并记录
+func saveVariant(ctx context.Context, imageID int64, img image.Image, p global.ImagePreset, format, key string) (*storedFile, error) {
+	img = imaging.Resize(img, p.Width, p.Height, p.Fit)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format, p.Quality); err != nil {
+		return nil, err
+	}
+
+	contentType, ext := imaging.ContentType(format)
+	obj, err := storage.Primary.Put(ctx, key+ext, bytes.NewReader(buf.Bytes()), int64(buf.Len()), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx, `
+			INSERT OR REPLACE INTO image_variants (image_id, variant, storage, file_id, chat_id, message_id, content_type, file_size, width, height)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			imageID, key, storage.Primary.Name(), obj.Key, obj.ChatID, obj.MessageID, contentType, buf.Len(), bounds.Dx(), bounds.Dy())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedFile{
+		storage:     storage.Primary.Name(),
+		fileID:      obj.Key,
+		contentType: contentType,
+		size:        int64(buf.Len()),
+		modTime:     time.Now().UTC(),
+	}, nil
+}
+
+// readOriginal 读取原图，优先使用本地缓存
+func readOriginal(ctx context.Context, file storedFile, dst io.Writer) error {
+	if cache.Default != nil {
+		if f, ok := cache.Default.Open(file.storage + ":" + file.fileID); ok {
+			defer f.Close()
+			_, err := io.Copy(dst, f)
+			return err
+		}
+	}
+
+	backend, _ := storage.Lookup(file.storage)
+	body, err := openImage(ctx, backend, file.storage, file.fileID, file.imageID, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(dst, body)
+	return err
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/storage"
+)
+
+// setupVariants 使用临时目录中的数据库和本地存储，保存 data 作为原图
+func setupVariants(t *testing.T, data []byte, contentType string) storedFile {
+	t.Helper()
+	dir := t.TempDir()
+	global.AppConfig = global.Config{}
+	global.AppConfig.Database.Path = filepath.Join(dir, "images.db")
+	global.AppConfig.Storage.Type = "local"
+	global.AppConfig.Storage.Local.Path = filepath.Join(dir, "files")
+	db.InitDB()
+	t.Cleanup(func() { global.DB.Close() })
+	storage.InitStorage()
+	global.VariantSemaphore = make(chan struct{}, 1)
+
+	obj, err := storage.Primary.Put(context.Background(), "original", bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storedFile{imageID: 1, storage: storage.Primary.Name(), fileID: obj.Key, contentType: contentType, size: int64(len(data))}
+}
+
+// 共用原图的去重记录等待生成完成后复用同一个文件，不再重复生成
+func TestGetVariantShared(t *testing.T) {
+	leader := setupVariants(t, testPNG(t), "image/png")
+	follower := leader
+	follower.imageID = 2
+	p := normalizePreset(global.ImagePreset{Width: 16, Format: "jpeg"})
+	format, key, err := variantFormat(leader.contentType, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flightKey := fmt.Sprintf("%s:%s/%s", leader.storage, leader.fileID, key)
+	call, _ := startVariant(flightKey, leader.imageID)
+	result := make(chan *storedFile)
+	go func() {
+		v, err := getVariant(context.Background(), follower, p)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- v
+	}()
+	time.Sleep(50 * time.Millisecond)
+	call.result, call.err = generateVariant(context.Background(), leader, p, format, key)
+	finishVariant(flightKey, call)
+	if call.err != nil {
+		t.Fatal(call.err)
+	}
+
+	v := <-result
+	if v == nil || v.fileID != call.result.fileID {
+		t.Fatalf("follower got %+v, want file %s", v, call.result.fileID)
+	}
+	stored, err := lookupVariant(follower.imageID, key)
+	if err != nil || stored == nil || stored.fileID != call.result.fileID {
+		t.Fatalf("follower record = %+v, %v", stored, err)
+	}
+}
+
+// 无法转换的原图在一段时间内直接返回错误
+func TestGetVariantNotTransformable(t *testing.T) {
+	file := setupVariants(t, []byte("not an image"), "image/png")
+	p := normalizePreset(global.ImagePreset{Width: 16})
+	if _, err := getVariant(context.Background(), file, p); !errors.Is(err, errNotTransformable) {
+		t.Fatalf("err = %v", err)
+	}
+	// 删除原图后仍返回缓存的错误，而不是读取失败
+	backend, _ := storage.Lookup(file.storage)
+	if err := backend.Delete(context.Background(), file.fileID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getVariant(context.Background(), file, p); !errors.Is(err, errNotTransformable) {
+		t.Fatalf("second err = %v", err)
+	}
+}
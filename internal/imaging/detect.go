@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// DetectContentType 根据文件开头的内容判断类型，在 http.DetectContentType 的基础上
+// 增加 AVIF、HEIC/HEIF、TIFF 和 SVG 的识别
+func DetectContentType(head []byte) string {
+	if ct := detectBMFF(head); ct != "" {
+		return ct
+	}
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	if isSVG(head) {
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(head)
+}
+
+// detectBMFF 根据 ISO BMFF ftyp 盒中的品牌识别 AVIF 和 HEIC/HEIF
+func detectBMFF(head []byte) string {
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	size := int(uint32(head[0])<<24 | uint32(head[1])<<16 | uint32(head[2])<<8 | uint32(head[3]))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+
+	// 主品牌之后依次为版本号和兼容品牌
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	has := func(names ...string) bool {
+		for _, b := range brands {
+			for _, name := range names {
+				if b == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("avif", "avis"):
+		return "image/avif"
+	case has("heic", "heix", "heim", "heis", "hevc", "hevx"):
+		return "image/heic"
+	case has("mif1", "msf1"):
+		return "image/heif"
+	}
+	return ""
+}
+
+// isSVG 判断内容是否为 SVG 文档，允许开头的 XML 声明、注释和 DOCTYPE
+func isSVG(head []byte) bool {
+	s := strings.TrimPrefix(string(head), "\xef\xbb\xbf")
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		switch {
+		case strings.HasPrefix(s, "<?"):
+			_, s, _ = strings.Cut(s, "?>")
+		case strings.HasPrefix(s, "<!--"):
+			_, s, _ = strings.Cut(s, "-->")
+		case strings.HasPrefix(s, "<!"):
+			_, s, _ = strings.Cut(s, ">")
+		default:
+			return strings.HasPrefix(s, "<svg") && len(s) > 4 && strings.ContainsRune(" \t\r\n>/", rune(s[4]))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"hosting/internal/imaging/vp8"
+
+	"github.com/HugoSmits86/nativewebp"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("imaging: unsupported output format")
+	ErrTooLarge          = errors.New("imaging: image dimensions too large")
+)
+
+// Encoder 将图片编码为指定格式，quality 取值 1-100，部分格式忽略
+type Encoder func(w io.Writer, img image.Image, quality int) error
+
+type format struct {
+	contentType string
+	ext         string
+	encode      Encoder
+}
+
+var formats = map[string]format{
+	"jpeg": {"image/jpeg", ".jpg", func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}},
+	"png": {"image/png", ".png", func(w io.Writer, img image.Image, quality int) error {
+		return png.Encode(w, img)
+	}},
+	"gif": {"image/gif", ".gif", func(w io.Writer, img image.Image, quality int) error {
+		return gif.Encode(w, img, nil)
+	}},
+	// quality 为 100 或图片带透明度时无损编码，否则有损编码
+	"webp": {"image/webp", ".webp", func(w io.Writer, img image.Image, quality int) error {
+		if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() && quality < 100 {
+			return vp8.Encode(w, img, quality)
+		}
+		return nativewebp.Encode(w, img, nil)
+	}},
+}
+
+// decodeOnly 可以解码但不能输出的格式
+var decodeOnly = map[string]string{
+	"image/bmp":  "bmp",
+	"image/tiff": "tiff",
+}
+
+// RegisterEncoder 注册额外的输出格式
+func RegisterEncoder(name, contentType, ext string, encode Encoder) {
+	formats[name] = format{contentType: contentType, ext: ext, encode: encode}
+}
+
+// CanEncode 判断是否支持输出指定格式
+func CanEncode(name string) bool {
+	_, ok := formats[NormalizeFormat(name)]
+	return ok
+}
+
+// NormalizeFormat 统一格式名称，jpg 视为 jpeg
+func NormalizeFormat(name string) string {
+	name = strings.ToLower(name)
+	if name == "jpg" {
+		return "jpeg"
+	}
+	return name
+}
+
+// FormatOf 根据 MIME 类型返回可解码的格式名称，不支持解码时返回空字符串
+func FormatOf(contentType string) string {
+	for name, f := range formats {
+		if f.contentType == contentType {
+			return name
+		}
+	}
+	if contentType == "image/jpg" {
+		return "jpeg"
+	}
+	return decodeOnly[contentType]
+}
+
+// ContentType 返回格式对应的 MIME 类型和扩展名
+func ContentType(name string) (string, string) {
+	f := formats[NormalizeFormat(name)]
+	return f.contentType, f.ext
+}
+
+// Decode 解码图片，像素数超过 maxPixels 时拒绝解码以防解压炸弹，maxPixels 为 0 表示不限制
+func Decode(r io.ReadSeeker, maxPixels int64) (image.Image, string, error) {
+	cfg, name, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, name, ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, name, err
+	}
+	return image.Decode(r)
+}
+
+// Encode 按指定格式编码图片
+func Encode(w io.Writer, img image.Image, name string, quality int) error {
+	f, ok := formats[NormalizeFormat(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, name)
+	}
+	if quality <= 0 || quality > 100 {
+		quality = 85
+	}
+	return f.encode(w, img, quality)
+}
+
+// Resize 缩放图片，fit 取值：
+//   - contain（默认）：等比缩放至不超过 width×height
+//   - cover：等比缩放并居中裁剪，填满 width×height
+//   - fill：拉伸至 width×height
+//
+// width 或 height 为 0 时按另一边等比计算，不会放大图片
+func Resize(img image.Image, width, height int, fit string) image.Image {
+	src := img.Bounds()
+	sw, sh := src.Dx(), src.Dy()
+	if sw == 0 || sh == 0 || (width <= 0 && height <= 0) {
+		return img
+	}
+	if width <= 0 {
+		width = sw * height / sh
+	}
+	if height <= 0 {
+		height = sh * width / sw
+	}
+	width, height = max(width, 1), max(height, 1)
+
+	srcRect := src
+	dw, dh := width, height
+	switch fit {
+	case "fill":
+	case "cover":
+		// 按目标比例裁剪源图中心区域
+		if sw*height > sh*width {
+			cw := sh * width / height
+			x := src.Min.X + (sw-cw)/2
+			srcRect = image.Rect(x, src.Min.Y, x+cw, src.Max.Y)
+		} else {
+			ch := sw * height / width
+			y := src.Min.Y + (sh-ch)/2
+			srcRect = image.Rect(src.Min.X, y, src.Max.X, y+ch)
+		}
+	default:
+		if sw*height > sh*width {
+			dh = max(sh*width/sw, 1)
+		} else {
+			dw = max(sw*height/sh, 1)
+		}
+	}
+
+	// 不放大
+	if dw >= srcRect.Dx() && dh >= srcRect.Dy() {
+		if srcRect == src {
+			return img
+		}
+		dw, dh = srcRect.Dx(), srcRect.Dy()
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, srcRect, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,47 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	transparent := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			opaque.SetNRGBA(x, y, color.NRGBA{uint8(x * 6), uint8(y * 8), 90, 255})
+			transparent.SetNRGBA(x, y, color.NRGBA{uint8(x * 6), uint8(y * 8), 90, uint8(x * 6)})
+		}
+	}
+	tests := []struct {
+		name    string
+		img     image.Image
+		quality int
+		chunk   string
+	}{
+		{"opaque", opaque, 85, "VP8 "},
+		{"quality 100", opaque, 100, "VP8L"},
+		{"transparent", transparent, 85, "VP8L"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img, "webp", tt.quality); err != nil {
+				t.Fatal(err)
+			}
+			if chunk := string(buf.Bytes()[12:16]); chunk != tt.chunk {
+				t.Errorf("chunk = %q, want %q", chunk, tt.chunk)
+			}
+			img, _, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != tt.img.Bounds() {
+				t.Errorf("bounds = %v", img.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,927 @@
+package imaging
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"slices"
+)
+
+// 元数据类型
+const (
+	MetaICC     = "icc"
+	MetaEXIF    = "exif"
+	MetaXMP     = "xmp"
+	MetaIPTC    = "iptc"
+	MetaComment = "comment"
+)
+
+var errMalformed = errors.New("imaging: malformed image")
+
+// Stripped 移除元数据后的文件内容，由原文件中保留的区间和新生成的数据依次拼接而成
+type Stripped struct {
+	src    io.ReaderAt
+	pieces []piece
+	Image  image.Image // JPEG 按 EXIF 方向旋转后重新编码时为旋转后的图片，否则为 nil
+}
+
+// piece 原文件中 [off, off+n) 的内容；data 不为 nil 时为新生成的数据，zero 为 true 时为 n 个零字节
+type piece struct {
+	data   []byte
+	off, n int64
+	zero   bool
+}
+
+// copy 追加原文件中 [off, off+n) 的内容，与上一段相邻时合并
+func (s *Stripped) copy(off, n int64) {
+	if n <= 0 {
+		return
+	}
+	if k := len(s.pieces); k > 0 {
+		if last := &s.pieces[k-1]; last.data == nil && !last.zero && last.off+last.n == off {
+			last.n += n
+			return
+		}
+	}
+	s.pieces = append(s.pieces, piece{off: off, n: n})
+}
+
+// write 追加新生成的数据
+func (s *Stripped) write(data []byte) {
+	s.pieces = append(s.pieces, piece{data: data, n: int64(len(data))})
+}
+
+// Size 返回内容的长度
+func (s *Stripped) Size() int64 {
+	var n int64
+	for _, p := range s.pieces {
+		n += p.n
+	}
+	return n
+}
+
+// WriteTo 依次写出各段内容，原文件中的区间在写出时才读取
+func (s *Stripped) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, p := range s.pieces {
+		var r io.Reader
+		switch {
+		case p.data != nil:
+			r = bytes.NewReader(p.data)
+		case p.zero:
+			r = io.LimitReader(zeroReader{}, p.n)
+		default:
+			r = io.NewSectionReader(s.src, p.off, p.n)
+		}
+		n, err := io.Copy(w, r)
+		total += n
+		if err == nil && n < p.n {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// fillZero 保留原文件的结构，只把 ranges 中的区间填零，区间可以重叠
+func fillZero(s source, ranges [][2]int64) *Stripped {
+	slices.SortFunc(ranges, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	out := &Stripped{src: s.r}
+	var pos int64
+	for _, r := range ranges {
+		start := max(r[0], pos)
+		if r[1] <= start {
+			continue
+		}
+		out.copy(pos, start-pos)
+		out.pieces = append(out.pieces, piece{n: r[1] - start, zero: true})
+		pos = r[1]
+	}
+	out.copy(pos, s.size-pos)
+	return out
+}
+
+// StripMetadata 移除图片中 keep 以外的元数据，没有需要移除的内容时返回 nil。
+// 文件从 r 中按需读取，结果在 WriteTo 时才从 r 中复制，写出之前不能修改 r。
+// JPEG 带有 EXIF 方向且不保留 EXIF 时，先按方向旋转像素再重新编码，避免移除后图片方向错误；
+// img 为校验时已解码的图片，为 nil 时重新解码。
+// TIFF 和 AVIF/HEIC 的元数据填零而不删除，BMP、ICO 等不含元数据的格式原样返回。
+func StripMetadata(r io.ReaderAt, size int64, contentType string, keep []string, img image.Image) (*Stripped, error) {
+	s := source{r: r, size: size}
+	switch contentType {
+	case "image/jpeg", "image/jpg":
+		return stripJPEG(s, keep, img)
+	case "image/png":
+		return stripPNG(s, keep)
+	case "image/webp":
+		return stripWebP(s, keep)
+	case "image/gif":
+		return stripGIF(s, keep)
+	case "image/tiff":
+		return stripTIFF(s, keep)
+	case "image/avif", "image/heic", "image/heif":
+		return stripBMFF(s, keep)
+	}
+	return nil, nil
+}
+
+// jpegSegment JPEG 中位于 [off, off+n) 的段
+type jpegSegment struct {
+	off, n int64
+	marker byte
+}
+
+func stripJPEG(s source, keep []string, img image.Image) (*Stripped, error) {
+	if soi, err := s.read(0, 2); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errMalformed
+	}
+
+	var kept []jpegSegment
+	var structural []jpegSegment // JFIF、Adobe 等影响解码的段，重新编码时丢弃
+	removed := false
+	orientation := 1
+	pos := int64(2)
+	for {
+		header, err := s.read(pos, 4)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := header[1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		// SOS 之后为压缩数据，原样保留
+		if marker == 0xDA {
+			break
+		}
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		end := pos + 2 + length
+		if length < 2 || end > s.size {
+			return nil, errMalformed
+		}
+		segment := jpegSegment{off: pos, n: end - pos, marker: marker}
+		// 识别元数据类型只需要段开头的标识
+		prefix, err := s.read(pos+4, int(min(length-2, 32)))
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind := jpegSegmentKind(marker, prefix); kind {
+		case "":
+			if marker == 0xE0 || marker == 0xEE {
+				structural = append(structural, segment)
+			} else if marker < 0xE0 || marker > 0xEF {
+				// DQT、SOF、DHT 等非元数据段
+				kept = append(kept, segment)
+			} else {
+				// 其他 APPn 多为相机厂商数据，一律移除
+				removed = true
+			}
+		default:
+			if kind == MetaEXIF {
+				payload, err := s.read(pos+4, int(length-2))
+				if err != nil {
+					return nil, err
+				}
+				orientation = exifOrientation(payload[min(6, len(payload)):])
+			}
+			if slices.Contains(keep, kind) {
+				kept = append(kept, segment)
+			} else {
+				removed = true
+			}
+		}
+		pos = end
+	}
+
+	if orientation > 1 && !slices.Contains(keep, MetaEXIF) {
+		return reorientJPEG(s, img, orientation, kept)
+	}
+	if !removed {
+		return nil, nil
+	}
+
+	out := &Stripped{src: s.r}
+	out.copy(0, 2)
+	for _, seg := range structural {
+		out.copy(seg.off, seg.n)
+	}
+	for _, seg := range kept {
+		out.copy(seg.off, seg.n)
+	}
+	out.copy(pos, s.size-pos)
+	return out, nil
+}
+
+// jpegSegmentKind 返回 JPEG 段的元数据类型，非元数据段返回空字符串
+func jpegSegmentKind(marker byte, payload []byte) string {
+	switch {
+	case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00")):
+		return MetaEXIF
+	case marker == 0xE1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/")):
+		return MetaXMP
+	case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		return MetaICC
+	case marker == 0xED:
+		return MetaIPTC
+	case marker == 0xFE:
+		return MetaComment
+	}
+	return ""
+}
+
+// reorientJPEG 按 EXIF 方向旋转像素后重新编码，并写回保留的元数据段
+func reorientJPEG(s source, img image.Image, orientation int, kept []jpegSegment) (*Stripped, error) {
+	if img == nil {
+		var err error
+		if img, err = jpeg.Decode(s.section(0, s.size)); err != nil {
+			return nil, err
+		}
+	}
+	oriented := Orient(img, orientation)
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, oriented, &jpeg.Options{Quality: 92}); err != nil {
+		return nil, err
+	}
+
+	out := &Stripped{src: s.r, Image: oriented}
+	out.write(encoded.Bytes()[:2])
+	for _, seg := range kept {
+		// 重新编码后只保留元数据段，量化表等由编码器生成
+		if seg.marker >= 0xE0 && seg.marker <= 0xEF || seg.marker == 0xFE {
+			out.copy(seg.off, seg.n)
+		}
+	}
+	out.write(encoded.Bytes()[2:])
+	return out, nil
+}
+
+// exifOrientation 从 TIFF 格式的 EXIF 数据中读取方向，读取失败时返回 1
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// Orient 按 EXIF 方向（1-8）变换图片
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转 90°
+				dx, dy = h-1-y, x
+			case 7: // 沿右上-左下对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转 90°
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNG(s source, keep []string) (*Stripped, error) {
+	if sig, err := s.read(0, len(pngSignature)); err != nil || !bytes.Equal(sig, pngSignature) {
+		return nil, errMalformed
+	}
+	out := &Stripped{src: s.r}
+	out.copy(0, int64(len(pngSignature)))
+	removed := false
+	xmpKeyword := []byte("XML:com.adobe.xmp\x00")
+
+	pos := int64(len(pngSignature))
+	for pos < s.size {
+		header, err := s.read(pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		end := pos + 12 + length
+		if end > s.size {
+			return nil, errMalformed
+		}
+		chunkType := string(header[4:8])
+
+		kind := ""
+		switch chunkType {
+		case "iCCP":
+			kind = MetaICC
+		case "eXIf":
+			kind = MetaEXIF
+		case "iTXt":
+			kind = MetaComment
+			if length >= int64(len(xmpKeyword)) {
+				keyword, err := s.read(pos+8, len(xmpKeyword))
+				if err != nil {
+					return nil, err
+				}
+				if bytes.Equal(keyword, xmpKeyword) {
+					kind = MetaXMP
+				}
+			}
+		case "tEXt", "zTXt", "tIME":
+			kind = MetaComment
+		}
+		if kind == "" || slices.Contains(keep, kind) {
+			out.copy(pos, end-pos)
+		} else {
+			removed = true
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	if !removed {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func stripWebP(s source, keep []string) (*Stripped, error) {
+	header, err := s.read(0, 12)
+	if err != nil || string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	// 没有 VP8X 扩展头的简单格式不含元数据
+	if s.size < 30 {
+		return nil, nil
+	}
+	if first, err := s.read(12, 4); err != nil || string(first) != "VP8X" {
+		return nil, err
+	}
+
+	out := &Stripped{src: s.r}
+	out.write(header)
+	var vp8x []byte
+	var flags byte
+	removed := false
+
+	pos := int64(12)
+	for pos+8 <= s.size {
+		chunkHeader, err := s.read(pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		chunkType := string(chunkHeader[:4])
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		end := pos + 8 + size + size%2
+		if end == s.size+1 {
+			// 末尾奇数长度的块可能缺少填充字节
+			end = s.size
+		}
+		if end > s.size {
+			return nil, errMalformed
+		}
+
+		kind := ""
+		switch chunkType {
+		case "ICCP":
+			kind = MetaICC
+		case "EXIF":
+			kind = MetaEXIF
+		case "XMP ":
+			kind = MetaXMP
+		}
+		if kind != "" && !slices.Contains(keep, kind) {
+			removed = true
+			pos = end
+			continue
+		}
+
+		if chunkType == "VP8X" && vp8x == nil && size >= 10 {
+			// 标志位在 VP8X 数据的第一个字节，之后更新
+			if vp8x, err = s.read(pos, 18); err != nil {
+				return nil, err
+			}
+			out.write(vp8x)
+			out.copy(pos+18, end-pos-18)
+		} else {
+			out.copy(pos, end-pos)
+		}
+		switch kind {
+		case MetaICC:
+			flags |= 0x20
+		case MetaEXIF:
+			flags |= 0x08
+		case MetaXMP:
+			flags |= 0x04
+		}
+		pos = end
+	}
+	if !removed {
+		return nil, nil
+	}
+
+	if vp8x != nil {
+		// 更新 VP8X 中的元数据标志位
+		vp8x[8] = vp8x[8]&^0x2C | flags
+	}
+	binary.LittleEndian.PutUint32(header[4:], uint32(out.Size()-8))
+	return out, nil
+}
+
+// stripGIF 移除注释扩展和应用扩展，保留循环次数等动画相关的扩展
+func stripGIF(s source, keep []string) (*Stripped, error) {
+	header, err := gifHeaderSize(s)
+	if err != nil {
+		return nil, err
+	}
+	out := &Stripped{src: s.r}
+	out.copy(0, header)
+	removed := false
+
+	end, err := gifBlocks(s, func(label byte, start, end int64) error {
+		kind := ""
+		switch label {
+		case 0xFE:
+			kind = MetaComment
+		case 0xFF:
+			// 应用扩展的第一个子块为 11 字节的应用标识
+			app := ""
+			if end-start >= 14 {
+				block, err := s.read(start, 14)
+				if err != nil {
+					return err
+				}
+				if block[2] == 11 {
+					app = string(block[3:14])
+				}
+			}
+			switch app {
+			case "NETSCAPE2.0", "ANIMEXTS1.0":
+			case "XMP DataXMP":
+				kind = MetaXMP
+			case "ICCRGBG1012":
+				kind = MetaICC
+			default:
+				// 未知的应用扩展可能包含任意数据
+				kind = MetaComment
+			}
+		}
+		if kind == "" || slices.Contains(keep, kind) {
+			out.copy(start, end-start)
+		} else {
+			removed = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, nil
+	}
+	// 结尾标记
+	out.copy(end-1, 1)
+	return out, nil
+}
+
+// TIFF 中与元数据相关的标签
+var tiffMetadataTags = map[uint16]string{
+	270:   MetaComment, // ImageDescription
+	271:   MetaEXIF,    // Make
+	272:   MetaEXIF,    // Model
+	305:   MetaEXIF,    // Software
+	306:   MetaEXIF,    // DateTime
+	315:   MetaEXIF,    // Artist
+	316:   MetaEXIF,    // HostComputer
+	700:   MetaXMP,
+	33723: MetaIPTC,
+	34377: MetaIPTC, // Photoshop 资源
+	34665: MetaEXIF, // Exif IFD
+	34675: MetaICC,
+	34853: MetaEXIF, // GPS IFD
+	40965: MetaEXIF, // Interoperability IFD
+}
+
+// tiffTypeSizes TIFF 各数据类型的字节数
+var tiffTypeSizes = [...]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// stripTIFF 将 TIFF 中元数据标签的值和 Exif/GPS 子 IFD 填零。
+// 只改写数据不移动位置，图像数据的偏移量保持不变；子 IFD 的条目数变为 0，仍是合法的空 IFD。
+func stripTIFF(s source, keep []string) (*Stripped, error) {
+	header, err := s.read(0, 8)
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		// 包括 BigTIFF
+		return nil, errMalformed
+	}
+
+	var zeroed [][2]int64
+	visited := make(map[uint32]bool)
+	var clearIFD func(offset uint32, clearAll bool) error
+	clearIFD = func(offset uint32, clearAll bool) error {
+		for offset != 0 {
+			if visited[offset] || len(visited) > 1000 {
+				return errMalformed
+			}
+			visited[offset] = true
+			pos := int64(offset)
+			countBytes, err := s.read(pos, 2)
+			if err != nil {
+				return err
+			}
+			count := int(order.Uint16(countBytes))
+			ifd, err := s.read(pos+2, 12*count+4)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < count; i++ {
+				entry := ifd[12*i:]
+				valuePos := pos + 2 + int64(12*i) + 8
+				tag, typ, n := order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:])
+				kind, isMeta := tiffMetadataTags[tag]
+				if !clearAll && (!isMeta || slices.Contains(keep, kind)) {
+					continue
+				}
+				// 子 IFD 内的条目全部清除
+				if tag == 34665 || tag == 34853 || tag == 40965 {
+					if err := clearIFD(order.Uint32(entry[8:]), true); err != nil {
+						return err
+					}
+					continue
+				}
+				if int(typ) >= len(tiffTypeSizes) || tiffTypeSizes[typ] == 0 {
+					zeroed = append(zeroed, [2]int64{valuePos, valuePos + 4})
+					continue
+				}
+				size := uint64(tiffTypeSizes[typ]) * uint64(n)
+				if size <= 4 {
+					zeroed = append(zeroed, [2]int64{valuePos, valuePos + 4})
+					continue
+				}
+				valueOffset := uint64(order.Uint32(entry[8:]))
+				if valueOffset+size > uint64(s.size) {
+					return errMalformed
+				}
+				zeroed = append(zeroed, [2]int64{int64(valueOffset), int64(valueOffset + size)})
+			}
+
+			if clearAll {
+				zeroed = append(zeroed, [2]int64{pos, pos + 2 + int64(12*count)})
+				return nil
+			}
+			offset = order.Uint32(ifd[12*count:])
+		}
+		return nil
+	}
+
+	if err := clearIFD(order.Uint32(header[4:]), false); err != nil {
+		return nil, err
+	}
+	if len(zeroed) == 0 {
+		return nil, nil
+	}
+	return fillZero(s, zeroed), nil
+}
+
+// stripBMFF 将 AVIF、HEIC 等 ISO BMFF 文件中 Exif 和 XMP 元数据项的内容填零。
+// 元数据项由 meta 盒中的 iinf 声明，iloc 记录其在文件或 idat 中的位置；
+// 只改写数据不移动位置，所有偏移量保持不变。
+func stripBMFF(s source, keep []string) (*Stripped, error) {
+	metaOffset, metaSize := int64(-1), int64(0)
+	_, err := eachBox(s, 0, s.size, func(typ string, off, size int64) error {
+		if typ == "meta" && metaOffset < 0 {
+			metaOffset, metaSize = off, size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// meta 为 FullBox，子盒之前有 4 字节的版本和标志
+	if metaOffset < 0 || metaSize < 4 {
+		return nil, errMalformed
+	}
+	meta, err := readBox(s, metaOffset, metaSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var iinf, iloc []byte
+	idatOffset, idatSize := int64(-1), int64(0)
+	_, err = eachBox(bytesSource(meta), 4, metaSize, func(typ string, off, size int64) error {
+		switch typ {
+		case "iinf":
+			iinf = meta[off : off+size]
+		case "iloc":
+			iloc = meta[off : off+size]
+		case "idat":
+			idatOffset, idatSize = metaOffset+off, size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iinf == nil || iloc == nil {
+		return nil, nil
+	}
+
+	items, err := bmffMetadataItems(iinf)
+	if err != nil {
+		return nil, err
+	}
+	for id, kind := range items {
+		if slices.Contains(keep, kind) {
+			delete(items, id)
+		}
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	var zeroed [][2]int64
+	err = eachItemExtent(iloc, func(id uint32, method int, offset, length uint64) error {
+		if _, ok := items[id]; !ok {
+			return nil
+		}
+		limit := uint64(s.size)
+		switch method {
+		case 0:
+		case 1:
+			// 相对 idat 盒的数据
+			if idatOffset < 0 {
+				return errMalformed
+			}
+			offset += uint64(idatOffset)
+			limit = uint64(idatOffset + idatSize)
+		default:
+			return errMalformed
+		}
+		// 长度为 0 表示直到文件结尾，不会用于元数据
+		if length == 0 || offset > limit || length > limit-offset {
+			return errMalformed
+		}
+		zeroed = append(zeroed, [2]int64{int64(offset), int64(offset + length)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(zeroed) == 0 {
+		return nil, nil
+	}
+	return fillZero(s, zeroed), nil
+}
+
+// bmffMetadataItems 从 iinf 盒中找出 Exif 和 XMP 元数据项，返回项 ID 和元数据类型
+func bmffMetadataItems(iinf []byte) (map[uint32]string, error) {
+	// 版本和标志之后为项数，版本 0 时为 16 位
+	if len(iinf) < 6 {
+		return nil, errMalformed
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return nil, errMalformed
+		}
+		entries = iinf[8:]
+	}
+
+	items := make(map[uint32]string)
+	_, err := eachBox(bytesSource(entries), 0, int64(len(entries)), func(typ string, off, size int64) error {
+		infe := entries[off : off+size]
+		// 版本 2 之前的 infe 没有项类型，不会是图片中的元数据项
+		if typ != "infe" || len(infe) < 4 || infe[0] < 2 {
+			return nil
+		}
+		var id uint32
+		rest := infe[4:]
+		if infe[0] == 2 {
+			if len(rest) < 2 {
+				return errMalformed
+			}
+			id, rest = uint32(binary.BigEndian.Uint16(rest)), rest[2:]
+		} else {
+			if len(rest) < 4 {
+				return errMalformed
+			}
+			id, rest = binary.BigEndian.Uint32(rest), rest[4:]
+		}
+		// 跳过 item_protection_index
+		if len(rest) < 6 {
+			return errMalformed
+		}
+		itemType, rest := string(rest[2:6]), rest[6:]
+
+		switch itemType {
+		case "Exif":
+			items[id] = MetaEXIF
+		case "mime":
+			// item_name 之后是 content_type，均以零结尾
+			_, rest, _ = bytes.Cut(rest, []byte{0})
+			contentType, _, _ := bytes.Cut(rest, []byte{0})
+			if string(contentType) == "application/rdf+xml" {
+				items[id] = MetaXMP
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// eachItemExtent 遍历 iloc 盒中各项的数据区间，method 为 construction_method
+func eachItemExtent(iloc []byte, fn func(id uint32, method int, offset, length uint64) error) error {
+	r := bmffReader{data: iloc}
+	version := r.uint(1)
+	r.uint(3)
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var id uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		method := 0
+		if version == 1 || version == 2 {
+			method = int(r.uint(2) & 0x0F)
+		}
+		r.uint(2) // data_reference_index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		for j := uint64(0); j < extents && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset, length := r.uint(offsetSize), r.uint(lengthSize)
+			if r.err != nil {
+				break
+			}
+			if err := fn(uint32(id), method, base+offset, length); err != nil {
+				return err
+			}
+		}
+	}
+	return r.err
+}
+
+// bmffReader 按大端序依次读取变长整数，越界时记录错误
+type bmffReader struct {
+	data []byte
+	err  error
+}
+
+func (r *bmffReader) uint(size int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if size != 0 && size != 1 && size != 2 && size != 3 && size != 4 && size != 8 || size > len(r.data) {
+		r.err = errMalformed
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return v
+}
+
+// maxBoxSize 需要整体读取的 meta 等盒的最大长度
+const maxBoxSize = 16 << 20
+
+// readBox 读取盒的内容
+func readBox(s source, off, size int64) ([]byte, error) {
+	if size > maxBoxSize {
+		return nil, fmt.Errorf("%w: box too large", errMalformed)
+	}
+	return s.read(off, int(size))
+}
+
+// eachBox 遍历 [start, end) 中的 ISO BMFF 盒，盒内容位于 [off, off+size)。
+// 返回最后一个盒的结尾位置，之后只允许零字节填充
+func eachBox(s source, start, end int64, fn func(typ string, off, size int64) error) (int64, error) {
+	pos := start
+	for pos+8 <= end {
+		header, err := s.read(pos, 8)
+		if err != nil {
+			return 0, err
+		}
+		size := uint64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+		case 1:
+			if pos+16 > end {
+				return 0, errMalformed
+			}
+			large, err := s.read(pos+8, 8)
+			if err != nil {
+				return 0, err
+			}
+			size, headerSize = binary.BigEndian.Uint64(large), 16
+		}
+		if size < uint64(headerSize) || size > uint64(end-pos) {
+			break
+		}
+		if err := fn(string(header[4:8]), pos+headerSize, int64(size)-headerSize); err != nil {
+			return 0, err
+		}
+		pos += int64(size)
+	}
+
+	// 允许结尾的零字节填充
+	zero, err := s.allZero(pos, end)
+	if err != nil {
+		return 0, err
+	}
+	if !zero {
+		return 0, errMalformed
+	}
+	return pos, nil
+}
@@ -0,0 +1,237 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// box 生成 ISO BMFF 盒
+func box(typ string, payloads ...[]byte) []byte {
+	body := bytes.Join(payloads, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// testHEIF 生成带有 Exif 和 XMP 项的 HEIF 文件。
+// useIdat 为 true 时元数据保存在 meta 的 idat 盒中，否则保存在 mdat 中
+func testHEIF(useIdat bool) (data []byte, exif, xmp []byte) {
+	exif = []byte("Exif\x00\x00GPS 31.2304N 121.4737E")
+	xmp = []byte(`<x:xmpmeta><rdf:RDF>GPS</rdf:RDF></x:xmpmeta>`)
+
+	infe := func(id uint16, typ string, extra string) []byte {
+		b := []byte{2, 0, 0, 0}
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0)
+		b = append(b, typ...)
+		return box("infe", b, []byte("\x00"+extra))
+	}
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 3},
+		infe(1, "hvc1", ""),
+		infe(2, "Exif", ""),
+		infe(3, "mime", "application/rdf+xml\x00"))
+
+	// iloc 中的偏移量依赖 meta 的长度，先用占位值生成一次以确定长度
+	build := func(exifOffset, xmpOffset uint32) []byte {
+		version, method := byte(0), []byte{}
+		if useIdat {
+			version, method = 1, []byte{0, 1}
+		}
+		iloc := []byte{version, 0, 0, 0, 0x44, 0x00, 0, 2}
+		for _, item := range []struct {
+			id     uint16
+			offset uint32
+			length int
+		}{{2, exifOffset, len(exif)}, {3, xmpOffset, len(xmp)}} {
+			iloc = binary.BigEndian.AppendUint16(iloc, item.id)
+			iloc = append(iloc, method...)
+			iloc = append(iloc, 0, 0, 0, 1)
+			iloc = binary.BigEndian.AppendUint32(iloc, item.offset)
+			iloc = binary.BigEndian.AppendUint32(iloc, uint32(item.length))
+		}
+		children := [][]byte{{0, 0, 0, 0}, box("hdlr", make([]byte, 24)), iinf, box("iloc", iloc)}
+		if useIdat {
+			children = append(children, box("idat", exif, xmp))
+		}
+		meta := box("meta", children...)
+		out := append(box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")), meta...)
+		if !useIdat {
+			out = append(out, box("mdat", exif, xmp, []byte("pixels"))...)
+		}
+		return out
+	}
+
+	data = build(0, 0)
+	if useIdat {
+		return build(0, uint32(len(exif))), exif, xmp
+	}
+	start := uint32(len(data) - len(exif) - len(xmp) - len("pixels"))
+	return build(start, start+uint32(len(exif))), exif, xmp
+}
+
+// testTIFF 在 TIFF 的 IFD0 中加入 Model 和 GPS IFD
+func testTIFF(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	order := binary.LittleEndian
+	ifd := int(order.Uint32(data[4:]))
+	count := int(order.Uint16(data[ifd:]))
+	entries := data[ifd+2 : ifd+2+12*count]
+
+	// 新的 IFD0 放在文件末尾：原有条目、Model 和 GPS 指针，之后是字符串和 GPS IFD
+	newIFD := len(data)
+	model := newIFD + 2 + 12*(count+2) + 4
+	gpsIFD := model + 12
+	latitude := gpsIFD + 2 + 12 + 4
+
+	entry := func(tag, typ uint16, n, value uint32) []byte {
+		b := order.AppendUint16(nil, tag)
+		b = order.AppendUint16(b, typ)
+		b = order.AppendUint32(b, n)
+		return order.AppendUint32(b, value)
+	}
+	out := bytes.Clone(data)
+	out = order.AppendUint16(out, uint16(count+2))
+	var inserted bool
+	for i := 0; i < count; i++ {
+		e := entries[12*i : 12*i+12]
+		if tag := order.Uint16(e); tag > 272 && !inserted {
+			out = append(out, entry(272, 2, 12, uint32(model))...)
+			inserted = true
+		}
+		out = append(out, e...)
+	}
+	out = append(out, entry(34853, 4, 1, uint32(gpsIFD))...)
+	out = order.AppendUint32(out, 0)
+	out = append(out, "PhoneModel1\x00"...)
+	out = order.AppendUint16(out, 1)
+	out = append(out, entry(2, 5, 3, uint32(latitude))...)
+	out = order.AppendUint32(out, 0)
+	for _, v := range []uint32{31, 1, 13, 1, 49, 1} {
+		out = order.AppendUint32(out, v)
+	}
+	order.PutUint32(out[4:], uint32(newIFD))
+	return out
+}
+
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+	frame := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{frame, frame},
+		Delay:     []int{10, 10},
+		LoopCount: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	header, err := gifHeaderSize(bytesSource(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 在第一个块之前插入注释和 XMP 扩展
+	comment := []byte("\x21\xFE\x0Bsecret note\x00")
+	xmp := append([]byte("\x21\xFF\x0BXMP DataXMP\x03GPS"), 0)
+	return bytes.Join([][]byte{data[:header], comment, xmp, data[header:]}, nil)
+}
+
+// strip 移除内存中图片的元数据，没有需要移除的内容时返回原数据
+func strip(t *testing.T, data []byte, contentType string, keep []string) []byte {
+	t.Helper()
+	stripped, err := StripMetadata(bytes.NewReader(data), int64(len(data)), contentType, keep, nil)
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+	if stripped == nil {
+		return data
+	}
+	var out bytes.Buffer
+	if _, err := stripped.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if int64(out.Len()) != stripped.Size() {
+		t.Fatalf("wrote %d bytes, Size() = %d", out.Len(), stripped.Size())
+	}
+	return out.Bytes()
+}
+
+func TestStripMetadata(t *testing.T) {
+	heif, heifExif, heifXMP := testHEIF(false)
+	heifIdat, _, _ := testHEIF(true)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		keep        []string
+		gone        [][]byte // 移除后不应再出现的内容
+		kept        [][]byte // 应保留的内容
+	}{
+		{"heif mdat", heif, "image/heic", nil, [][]byte{heifExif, []byte("rdf:RDF")}, [][]byte{[]byte("pixels")}},
+		{"heif idat", heifIdat, "image/heif", nil, [][]byte{[]byte("GPS 31"), []byte("rdf:RDF")}, nil},
+		{"heif keep xmp", heif, "image/avif", []string{MetaXMP}, [][]byte{[]byte("GPS 31")}, [][]byte{heifXMP}},
+		{"tiff", testTIFF(t), "image/tiff", nil, [][]byte{[]byte("PhoneModel")}, nil},
+		{"gif", testGIF(t), "image/gif", nil, [][]byte{[]byte("secret"), []byte("XMP Data")}, [][]byte{[]byte("NETSCAPE2.0")}},
+		{"gif keep comment", testGIF(t), "image/gif", []string{MetaComment}, [][]byte{[]byte("XMP Data")}, [][]byte{[]byte("secret")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := strip(t, tt.data, tt.contentType, tt.keep)
+			for _, b := range tt.gone {
+				if !bytes.Contains(tt.data, b) {
+					t.Fatalf("test input does not contain %q", b)
+				}
+				if bytes.Contains(out, b) {
+					t.Errorf("output still contains %q", b)
+				}
+			}
+			for _, b := range tt.kept {
+				if !bytes.Contains(out, b) {
+					t.Errorf("output lost %q", b)
+				}
+			}
+		})
+	}
+}
+
+func TestStripTIFFKeepsImage(t *testing.T) {
+	data := testTIFF(t)
+	latitude := binary.LittleEndian.AppendUint32(nil, 31)
+	if !bytes.Contains(data, latitude) {
+		t.Fatal("test input has no GPS latitude")
+	}
+	out := strip(t, data, "image/tiff", nil)
+	if len(out) != len(data) {
+		t.Fatalf("size changed: %d -> %d", len(data), len(out))
+	}
+	// GPS 纬度的有理数值被清零
+	if bytes.Contains(out, latitude) {
+		t.Error("GPS latitude still present")
+	}
+	if _, err := tiff.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("decode stripped tiff: %v", err)
+	}
+}
+
+func TestStripGIFKeepsAnimation(t *testing.T) {
+	out := strip(t, testGIF(t), "image/gif", nil)
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode stripped gif: %v", err)
+	}
+	if len(g.Image) != 2 || g.LoopCount != 0 {
+		t.Errorf("got %d frames, loop %d", len(g.Image), g.LoopCount)
+	}
+}
@@ -0,0 +1,47 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash 计算 64 位差异哈希：缩小为 9×8 灰度图，逐行比较相邻像素的亮度。
+// 缩放、重新压缩后的图片哈希相近，可用汉明距离判断相似度。
+func DHash(img image.Image) uint64 {
+	gray := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.CatmullRom.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// FormatHash 将哈希格式化为 16 位十六进制字符串
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash 解析 FormatHash 生成的字符串
+func ParseHash(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, err == nil
+}
+
+// Distance 返回两个哈希的汉明距离
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// source 按需读取的文件内容，校验和移除元数据时只读取结构信息，不把整个文件载入内存
+type source struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// bytesSource 返回内存中数据的 source
+func bytesSource(data []byte) source {
+	return source{r: bytes.NewReader(data), size: int64(len(data))}
+}
+
+// read 读取 off 处的 n 个字节，超出结尾时返回 errMalformed
+func (s source) read(off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off > s.size || int64(n) > s.size-off {
+		return nil, errMalformed
+	}
+	buf := make([]byte, n)
+	if m, err := s.r.ReadAt(buf, off); m < n {
+		return nil, unexpectedEOF(err)
+	}
+	return buf, nil
+}
+
+// section 返回 off 处长度为 n 的内容
+func (s source) section(off, n int64) *io.SectionReader {
+	return io.NewSectionReader(s.r, off, n)
+}
+
+// allZero 判断 [off, end) 是否全部为零字节
+func (s source) allZero(off, end int64) (bool, error) {
+	buf := make([]byte, 32*1024)
+	for off < end {
+		n, err := s.r.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		off += int64(n)
+		if err != nil && off < end {
+			return false, unexpectedEOF(err)
+		}
+	}
+	return true, nil
+}
+
+// cursor 从 source 的某个位置开始顺序读取，用于需要逐字节扫描的结构
+type cursor struct {
+	br  *bufio.Reader
+	pos int64 // 下一个字节在 source 中的位置
+}
+
+func newCursor(s source, off int64) *cursor {
+	return &cursor{br: bufio.NewReader(s.section(off, s.size-off)), pos: off}
+}
+
+func (c *cursor) byte() (byte, error) {
+	b, err := c.br.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	c.pos++
+	return b, nil
+}
+
+func (c *cursor) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	m, err := io.ReadFull(c.br, buf)
+	c.pos += int64(m)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf, nil
+}
+
+func (c *cursor) skip(n int) error {
+	m, err := c.br.Discard(n)
+	c.pos += int64(m)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	return nil
+}
+
+// skipPast 跳过直到下一个 delim 字节之后
+func (c *cursor) skipPast(delim byte) error {
+	for {
+		chunk, err := c.br.ReadSlice(delim)
+		c.pos += int64(len(chunk))
+		if err == nil {
+			return nil
+		}
+		if err != bufio.ErrBufferFull {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+// unexpectedEOF 将读到结尾视为文件结构不完整，其他读取错误原样返回
+func unexpectedEOF(err error) error {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errMalformed
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// SVGPolicy 输出 SVG 时使用的 Content-Security-Policy，禁止脚本和外部资源
+const SVGPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+// 会执行脚本或嵌入外部内容的元素，连同子元素一起移除
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+var (
+	// 引用外部资源的 CSS，url(#id) 之外的 url() 以及 @import
+	svgExternalCSS = regexp.MustCompile(`(?i)@import|url\(\s*['"]?\s*[^\s'"#)]`)
+	// 允许内嵌的位图
+	svgDataImage = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp);base64,`)
+	// 与 xml.EscapeText 不同，保留换行以免改变文档排版
+	svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// SanitizeSVG 重新序列化 SVG 文档，移除脚本、事件处理属性和外部引用。
+// 含有 DOCTYPE 或无法解析的文档直接拒绝，此时 w 中可能已写入部分内容。
+func SanitizeSVG(w io.Writer, r io.Reader) error {
+	d := xml.NewDecoder(r)
+	out := bufio.NewWriter(w)
+	skip := 0 // 大于 0 时位于被移除的元素内
+	root := true
+	var open []xml.Name // 已输出且未闭合的元素，RawToken 不检查标签是否匹配
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(open) == 0 && skip == 0 {
+				if !root || strings.ToLower(t.Name.Local) != "svg" {
+					return errors.New("imaging: root element is not svg")
+				}
+				root = false
+			}
+			if skip > 0 || svgBlockedElements[strings.ToLower(t.Name.Local)] {
+				skip++
+				continue
+			}
+			out.WriteByte('<')
+			out.WriteString(qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				if !safeSVGAttr(attr) {
+					continue
+				}
+				out.WriteByte(' ')
+				out.WriteString(qualifiedName(attr.Name))
+				out.WriteString(`="`)
+				out.WriteString(svgEscaper.Replace(attr.Value))
+				out.WriteByte('"')
+			}
+			out.WriteByte('>')
+			open = append(open, t.Name)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(open) == 0 || open[len(open)-1] != t.Name {
+				return errors.New("imaging: mismatched svg end tag")
+			}
+			open = open[:len(open)-1]
+			out.WriteString("</")
+			out.WriteString(qualifiedName(t.Name))
+			out.WriteByte('>')
+		case xml.CharData:
+			if skip > 0 || len(open) == 0 {
+				continue
+			}
+			// 样式表引用外部资源时整段移除
+			if strings.EqualFold(open[len(open)-1].Local, "style") && svgExternalCSS.Match(t) {
+				continue
+			}
+			out.WriteString(svgEscaper.Replace(string(t)))
+		case xml.ProcInst:
+			if t.Target == "xml" && root {
+				out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+			}
+		case xml.Directive:
+			return errors.New("imaging: svg doctype not allowed")
+		}
+	}
+
+	if root || len(open) > 0 || skip > 0 {
+		return errors.New("imaging: incomplete svg")
+	}
+	return out.Flush()
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// safeSVGAttr 判断属性是否可以保留
+func safeSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.ToLower(strings.Join(strings.Fields(attr.Value), ""))
+
+	if strings.HasPrefix(name, "on") || strings.Contains(value, "javascript:") {
+		return false
+	}
+	if name == "href" || name == "src" {
+		// 只允许文档内引用和内嵌位图
+		return strings.HasPrefix(value, "#") || svgDataImage.MatchString(value)
+	}
+	// 动画可以把属性改成脚本地址
+	if name == "attributename" && (strings.HasSuffix(value, "href") || strings.HasPrefix(value, "on")) {
+		return false
+	}
+	return !svgExternalCSS.MatchString(attr.Value)
+}
@@ -0,0 +1,125 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		gone []string // 输出中不应出现的内容（不区分大小写）
+		kept []string
+	}{
+		{
+			"script element",
+			`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1"/></svg>`,
+			[]string{"script", "alert"},
+			[]string{`<rect width="1">`},
+		},
+		{
+			"nested script in foreignObject",
+			`<svg><foreignObject><body><script>alert(1)</script></body></foreignObject><circle r="1"/></svg>`,
+			[]string{"foreignobject", "alert", "body"},
+			[]string{`<circle r="1">`},
+		},
+		{
+			"event handlers",
+			`<svg onload="alert(1)"><rect ONCLICK="alert(2)" onMouseOver="x()" fill="red"/></svg>`,
+			[]string{"onload", "onclick", "onmouseover", "alert"},
+			[]string{`fill="red"`},
+		},
+		{
+			"javascript href",
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="javascript:alert(1)"><text>x</text></a></svg>`,
+			[]string{"javascript", "href"},
+			[]string{"<text>x</text>"},
+		},
+		{
+			"obfuscated javascript",
+			`<svg><a href="  JaVa&#x09;Script:alert(1)">x</a><a href="jav&#x61;script:alert(2)">y</a></svg>`,
+			[]string{"script:", "alert"},
+			nil,
+		},
+		{
+			"animate into href",
+			`<svg><a><set attributeName="href" to="javascript:alert(1)"/><animate attributeName="onclick" values="x"/></a></svg>`,
+			[]string{"attributename", "javascript"},
+			nil,
+		},
+		{
+			"external references",
+			`<svg><image href="https://evil.example/x.png"/><style>@import url(https://evil.example/a.css);</style><rect style="fill:url(https://evil.example/#x)"/></svg>`,
+			[]string{"evil.example"},
+			nil,
+		},
+		{
+			"local references kept",
+			`<svg><use href="#icon"/><rect fill="url(#grad)"/><image href="data:image/png;base64,AAAA"/></svg>`,
+			nil,
+			[]string{`href="#icon"`, `fill="url(#grad)"`, `href="data:image/png;base64,AAAA"`},
+		},
+		{
+			"escaped text",
+			`<svg><text>&lt;script&gt;alert(1)&lt;/script&gt;</text></svg>`,
+			[]string{"<script"},
+			[]string{"&lt;script&gt;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := SanitizeSVG(&buf, strings.NewReader(tt.in)); err != nil {
+				t.Fatalf("SanitizeSVG: %v", err)
+			}
+			out := buf.Bytes()
+			lower := strings.ToLower(string(out))
+			for _, s := range tt.gone {
+				if strings.Contains(lower, strings.ToLower(s)) {
+					t.Errorf("output still contains %q: %s", s, out)
+				}
+			}
+			for _, s := range tt.kept {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("output lost %q: %s", s, out)
+				}
+			}
+			// 输出仍是格式正确的 XML
+			d := xml.NewDecoder(bytes.NewReader(out))
+			for {
+				if _, err := d.Token(); err != nil {
+					if err != io.EOF {
+						t.Errorf("output is not well-formed: %v", err)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"doctype", `<!DOCTYPE svg [<!ENTITY x SYSTEM "file:///etc/passwd">]><svg>&x;</svg>`},
+		{"not svg", `<html><script>alert(1)</script></html>`},
+		{"second root", `<svg></svg><script>alert(1)</script>`},
+		{"mismatched tags", `<svg><g></svg></g>`},
+		{"unclosed", `<svg><g>`},
+		{"not xml", `GIF89a`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := SanitizeSVG(&out, strings.NewReader(tt.in)); err == nil {
+				t.Errorf("accepted: %s", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,517 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/webp"
+)
+
+var ErrFormatMismatch = errors.New("imaging: content does not match declared format")
+
+// Limits 图片尺寸限制，0 表示不限制
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// Validated 校验通过的图片
+type Validated struct {
+	image.Config
+	Image image.Image // 解码得到的图片，只校验结构的格式为 nil
+	End   int64       // 图片数据的结尾位置，之后拼接的内容（如 Motion Photo 的视频）应在保存前截掉
+}
+
+// Validate 用对应格式的解码器完整解码图片，拒绝格式不符、尺寸超限和截断的文件。
+// 内容从 r 中按需读取，不会把整个文件载入内存
+func Validate(r io.ReaderAt, size int64, contentType string, limits Limits) (Validated, error) {
+	s := source{r: r, size: size}
+	// 没有解码器的格式只校验文件结构
+	switch contentType {
+	case "image/avif", "image/heic", "image/heif":
+		return validateBMFF(s, limits)
+	case "image/x-icon":
+		return validateICO(s, limits)
+	}
+
+	var v Validated
+	expected := FormatOf(contentType)
+	if expected == "" {
+		return v, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	cfg, name, err := image.DecodeConfig(s.section(0, size))
+	if err != nil {
+		return v, err
+	}
+	v.Config = cfg
+	if name != expected {
+		return v, fmt.Errorf("%w: decoded as %s", ErrFormatMismatch, name)
+	}
+	if err := limits.check(cfg.Width, cfg.Height); err != nil {
+		return v, err
+	}
+
+	if v.End, err = imageEnd(s, name); err != nil {
+		return v, err
+	}
+
+	// x/image/webp 不支持动画，只校验块结构和各帧的头部
+	if name == "webp" && isAnimatedWebP(s) {
+		return v, validateAnimatedWebP(s, v.End, cfg, limits)
+	}
+
+	// 完整解码以发现截断或损坏的数据
+	v.Image, _, err = image.Decode(s.section(0, v.End))
+	return v, err
+}
+
+func (l Limits) check(width, height int) error {
+	if width <= 0 || height <= 0 ||
+		l.MaxWidth > 0 && width > l.MaxWidth ||
+		l.MaxHeight > 0 && height > l.MaxHeight ||
+		l.MaxPixels > 0 && int64(width)*int64(height) > l.MaxPixels {
+		return fmt.Errorf("%w: %dx%d", ErrTooLarge, width, height)
+	}
+	return nil
+}
+
+// imageEnd 按格式结构找到图片数据的结尾位置
+func imageEnd(s source, format string) (int64, error) {
+	switch format {
+	case "jpeg":
+		return jpegEnd(s)
+	case "png":
+		return pngEnd(s)
+	case "gif":
+		end, _, err := gifScan(s)
+		return end, err
+	case "webp":
+		return webpEnd(s)
+	case "bmp":
+		// 文件头中记录了文件大小，部分编码器写 0
+		header, err := s.read(0, 6)
+		if err != nil {
+			return 0, err
+		}
+		if size := int64(binary.LittleEndian.Uint32(header[2:])); size > 0 && size <= s.size {
+			return size, nil
+		}
+	}
+	return s.size, nil
+}
+
+func jpegEnd(s source) (int64, error) {
+	c := newCursor(s, 0)
+	if soi, err := c.read(2); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return 0, errMalformed
+	}
+	marker, err := jpegMarker(c)
+	for err == nil {
+		switch {
+		case marker == 0xD9:
+			return c.pos, nil
+		case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01:
+			marker, err = jpegMarker(c)
+			continue
+		}
+
+		var header []byte
+		if header, err = c.read(2); err != nil {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(header))
+		if length < 2 {
+			return 0, errMalformed
+		}
+		if err = c.skip(length - 2); err != nil {
+			break
+		}
+		if marker == 0xDA {
+			marker, err = jpegSkipScan(c)
+		} else {
+			marker, err = jpegMarker(c)
+		}
+	}
+	return 0, err
+}
+
+// jpegMarker 读取下一个标记，标记前可以有任意个 0xFF 填充
+func jpegMarker(c *cursor) (byte, error) {
+	b, err := c.byte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errMalformed
+	}
+	for b == 0xFF {
+		if b, err = c.byte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// jpegSkipScan 跳过压缩数据，返回之后第一个不是 RST 的标记
+func jpegSkipScan(c *cursor) (byte, error) {
+	for {
+		if err := c.skipPast(0xFF); err != nil {
+			return 0, err
+		}
+		b, err := c.byte()
+		for err == nil && b == 0xFF {
+			b, err = c.byte()
+		}
+		if err != nil {
+			return 0, err
+		}
+		// 0xFF00 为压缩数据中转义的 0xFF
+		if b != 0x00 && (b < 0xD0 || b > 0xD7) {
+			return b, nil
+		}
+	}
+}
+
+func pngEnd(s source) (int64, error) {
+	if sig, err := s.read(0, len(pngSignature)); err != nil || !bytes.Equal(sig, pngSignature) {
+		return 0, errMalformed
+	}
+	pos := int64(len(pngSignature))
+	for {
+		header, err := s.read(pos, 8)
+		if err != nil {
+			return 0, err
+		}
+		end := pos + 12 + int64(binary.BigEndian.Uint32(header))
+		if end > s.size {
+			return 0, errMalformed
+		}
+		if string(header[4:8]) == "IEND" {
+			return end, nil
+		}
+		pos = end
+	}
+}
+
+// IsAnimated 判断图片是否包含多帧，转换格式时只会保留第一帧
+func IsAnimated(r io.ReaderAt, size int64, contentType string) bool {
+	s := source{r: r, size: size}
+	switch contentType {
+	case "image/gif":
+		_, frames, err := gifScan(s)
+		return err == nil && frames > 1
+	case "image/webp":
+		return isAnimatedWebP(s)
+	}
+	return false
+}
+
+// gifScan 遍历 GIF 的各个块，返回结束位置和帧数
+func gifScan(s source) (end int64, frames int, err error) {
+	end, err = gifBlocks(s, func(label byte, start, end int64) error {
+		if label == gifImage {
+			frames++
+		}
+		return nil
+	})
+	return end, frames, err
+}
+
+// gifImage gifBlocks 中图像块使用的标签，扩展块的标签为扩展类型
+const gifImage = 0x2C
+
+// gifHeaderSize 返回文件头、逻辑屏幕描述符和全局颜色表的长度
+func gifHeaderSize(s source) (int64, error) {
+	header, err := s.read(0, 13)
+	if err != nil {
+		return 0, err
+	}
+	pos := int64(13)
+	if flags := header[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	if pos > s.size {
+		return 0, errMalformed
+	}
+	return pos, nil
+}
+
+// gifBlocks 依次遍历扩展块和图像块直到结尾标记，块位于 [start, end)，返回结尾标记之后的位置
+func gifBlocks(s source, fn func(label byte, start, end int64) error) (int64, error) {
+	pos, err := gifHeaderSize(s)
+	if err != nil {
+		return 0, err
+	}
+	c := newCursor(s, pos)
+	skipSubBlocks := func() error {
+		for {
+			n, err := c.byte()
+			if err != nil || n == 0 {
+				return err
+			}
+			if err := c.skip(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		start := c.pos
+		introducer, err := c.byte()
+		if err != nil {
+			return 0, err
+		}
+		var label byte
+		switch introducer {
+		case 0x3B:
+			return c.pos, nil
+		case 0x21:
+			if label, err = c.byte(); err != nil {
+				return 0, err
+			}
+		case gifImage:
+			label = gifImage
+			descriptor, err := c.read(9)
+			if err != nil {
+				return 0, err
+			}
+			// 局部颜色表和 LZW 最小码长
+			skip := 1
+			if flags := descriptor[8]; flags&0x80 != 0 {
+				skip += 3 << (flags&0x07 + 1)
+			}
+			if err := c.skip(skip); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, errMalformed
+		}
+		if err := skipSubBlocks(); err != nil {
+			return 0, err
+		}
+		if err := fn(label, start, c.pos); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func webpEnd(s source) (int64, error) {
+	header, err := s.read(0, 12)
+	if err != nil || string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return 0, errMalformed
+	}
+	end := int64(binary.LittleEndian.Uint32(header[4:])) + 8
+	if end > s.size {
+		return 0, errMalformed
+	}
+	return end, nil
+}
+
+// isAnimatedWebP 判断 VP8X 扩展头中是否设置了动画标志
+func isAnimatedWebP(s source) bool {
+	header, err := s.read(0, 21)
+	return err == nil && string(header[12:16]) == "VP8X" && header[20]&0x02 != 0
+}
+
+// validateAnimatedWebP 校验动画 WebP 的块结构，每个 ANMF 帧必须位于画布内，
+// 帧内的 VP8/VP8L 数据头部尺寸与帧头一致
+func validateAnimatedWebP(s source, end int64, canvas image.Config, limits Limits) error {
+	hasAnim, frames := false, 0
+	err := eachRIFFChunk(s, 12, end, func(fourCC string, off, size int64) error {
+		switch fourCC {
+		case "ANIM":
+			if size < 6 {
+				return errMalformed
+			}
+			hasAnim = true
+		case "ANMF":
+			if !hasAnim || size < 16 {
+				return errMalformed
+			}
+			frames++
+			header, err := s.read(off, 16)
+			if err != nil {
+				return err
+			}
+			x, y := 2*int(uint24(header[0:])), 2*int(uint24(header[3:]))
+			w, h := int(uint24(header[6:]))+1, int(uint24(header[9:]))+1
+			if x+w > canvas.Width || y+h > canvas.Height {
+				return fmt.Errorf("%w: frame outside canvas", errMalformed)
+			}
+			if err := limits.check(w, h); err != nil {
+				return err
+			}
+			return validateWebPFrame(s, off+16, off+size, w, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if frames == 0 {
+		return fmt.Errorf("%w: no animation frames", errMalformed)
+	}
+	return nil
+}
+
+// validateWebPFrame 校验 ANMF 帧数据，可选的 ALPH 块之后必须是一个 VP8 或 VP8L 块
+func validateWebPFrame(s source, start, end int64, width, height int) error {
+	hasBitstream := false
+	err := eachRIFFChunk(s, start, end, func(fourCC string, off, size int64) error {
+		switch fourCC {
+		case "ALPH":
+			if hasBitstream {
+				return errMalformed
+			}
+		case "VP8 ", "VP8L":
+			if hasBitstream {
+				return errMalformed
+			}
+			hasBitstream = true
+			// 将该块单独封装为简单格式的 WebP，读取头部中的尺寸
+			padded := size + size%2
+			riff := make([]byte, 20)
+			copy(riff, "RIFF\x00\x00\x00\x00WEBP")
+			binary.LittleEndian.PutUint32(riff[4:], uint32(12+padded))
+			copy(riff[12:], fourCC)
+			binary.LittleEndian.PutUint32(riff[16:], uint32(size))
+			chunk := io.MultiReader(bytes.NewReader(riff), s.section(off, size), bytes.NewReader(make([]byte, size%2)))
+			cfg, err := webp.DecodeConfig(chunk)
+			if err != nil {
+				return err
+			}
+			if cfg.Width != width || cfg.Height != height {
+				return fmt.Errorf("%w: frame size mismatch", errMalformed)
+			}
+		default:
+			return errMalformed
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !hasBitstream {
+		return fmt.Errorf("%w: frame without image data", errMalformed)
+	}
+	return nil
+}
+
+// eachRIFFChunk 遍历 [start, end) 中的 RIFF 块，块数据位于 [off, off+size)，
+// 按偶数长度对齐，末尾块允许缺少填充字节
+func eachRIFFChunk(s source, start, end int64, fn func(fourCC string, off, size int64) error) error {
+	pos := start
+	for pos < end {
+		if pos+8 > end {
+			return errMalformed
+		}
+		header, err := s.read(pos, 8)
+		if err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		off := pos + 8
+		if size > end-off {
+			return errMalformed
+		}
+		if err := fn(string(header[:4]), off, size); err != nil {
+			return err
+		}
+		pos = min(off+size+size%2, end)
+	}
+	return nil
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// validateBMFF 校验 AVIF、HEIC 等 ISO BMFF 容器的盒结构，尺寸取 ispe 属性中的最大值
+func validateBMFF(s source, limits Limits) (Validated, error) {
+	var v Validated
+	head, err := s.read(0, int(min(s.size, 512)))
+	if err != nil || detectBMFF(head) == "" {
+		return v, ErrFormatMismatch
+	}
+
+	hasMeta := false
+	v.End, err = eachBox(s, 0, s.size, func(typ string, off, size int64) error {
+		if typ != "meta" || hasMeta {
+			return nil
+		}
+		hasMeta = true
+		box, err := readBox(s, off, size)
+		if err != nil {
+			return err
+		}
+		for i := bytes.Index(box, []byte("ispe")); i >= 0; {
+			if i+16 <= len(box) {
+				w := int(binary.BigEndian.Uint32(box[i+8:]))
+				h := int(binary.BigEndian.Uint32(box[i+12:]))
+				v.Width, v.Height = max(v.Width, w), max(v.Height, h)
+			}
+			next := bytes.Index(box[i+4:], []byte("ispe"))
+			if next < 0 {
+				break
+			}
+			i += 4 + next
+		}
+		return nil
+	})
+	if err != nil {
+		return v, err
+	}
+
+	if !hasMeta {
+		return v, errMalformed
+	}
+	return v, limits.check(v.Width, v.Height)
+}
+
+// validateICO 校验 ICO 目录中各图片的位置和尺寸
+func validateICO(s source, limits Limits) (Validated, error) {
+	var v Validated
+	header, err := s.read(0, 6)
+	if err != nil || binary.LittleEndian.Uint16(header) != 0 {
+		return v, errMalformed
+	}
+	if t := binary.LittleEndian.Uint16(header[2:]); t != 1 && t != 2 {
+		return v, errMalformed
+	}
+	count := int(binary.LittleEndian.Uint16(header[4:]))
+	if count == 0 {
+		return v, errMalformed
+	}
+	dir, err := s.read(6, 16*count)
+	if err != nil {
+		return v, err
+	}
+
+	v.End = int64(6 + 16*count)
+	for i := 0; i < count; i++ {
+		entry := dir[16*i:]
+		// 宽高为 0 表示 256
+		w, h := int(entry[0]), int(entry[1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		v.Width, v.Height = max(v.Width, w), max(v.Height, h)
+
+		size := int64(binary.LittleEndian.Uint32(entry[8:]))
+		offset := int64(binary.LittleEndian.Uint32(entry[12:]))
+		if size <= 0 || offset < int64(6+16*count) || offset+size > s.size {
+			return v, errMalformed
+		}
+		v.End = max(v.End, offset+size)
+	}
+	return v, limits.check(v.Width, v.Height)
+}
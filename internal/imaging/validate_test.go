@@ -0,0 +1,62 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	// Motion Photo 在 JPEG 结尾之后附加 MP4
+	motion := append(bytes.Clone(jpg.Bytes()), "\x00\x00\x00\x18ftypmp42 video data"...)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		end         int
+		err         error
+	}{
+		{"jpeg", jpg.Bytes(), "image/jpeg", jpg.Len(), nil},
+		{"motion photo", motion, "image/jpeg", jpg.Len(), nil},
+		{"png with trailing data", append(bytes.Clone(pngData.Bytes()), "<?php ?>"...), "image/png", pngData.Len(), nil},
+		{"truncated jpeg", jpg.Bytes()[:jpg.Len()-10], "image/jpeg", 0, errMalformed},
+		{"truncated png", pngData.Bytes()[:pngData.Len()-12], "image/png", 0, errMalformed},
+		{"mismatch", pngData.Bytes(), "image/jpeg", 0, ErrFormatMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Validate(bytes.NewReader(tt.data), int64(len(tt.data)), tt.contentType, Limits{})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.End != int64(tt.end) {
+				t.Errorf("End = %d, want %d", v.End, tt.end)
+			}
+			if v.Image == nil || v.Width != 16 || v.Height != 8 {
+				t.Errorf("got %dx%d, image %v", v.Width, v.Height, v.Image != nil)
+			}
+		})
+	}
+
+	if _, err := Validate(bytes.NewReader(jpg.Bytes()), int64(jpg.Len()), "image/jpeg", Limits{MaxPixels: 100}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("pixel limit: %v", err)
+	}
+}
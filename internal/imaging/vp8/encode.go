@@ -0,0 +1,529 @@
+// Package vp8 实现有损 WebP（VP8 关键帧）编码
+//
+// 编码器只使用 16x16 亮度预测和 8x8 色度预测，系数概率按整张图片的统计结果更新，
+// 输出可以被任何符合 RFC 6386 的解码器解码
+package vp8
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// ErrDimensions 图片宽高超出 VP8 支持的范围
+var ErrDimensions = errors.New("vp8: image dimensions out of range")
+
+// maxDimension VP8 帧头用 14 位记录宽高
+const maxDimension = 1<<14 - 1
+
+// 预测模式
+const (
+	predDC = iota
+	predVE
+	predHE
+	predTM
+	nPred
+)
+
+// quantMatrix 一类系数的量化参数
+type quantMatrix struct {
+	q    [2]int32 // DC、AC 的量化步长
+	bias [2]int32 // 舍入偏移，单位为 1/256 个步长
+	max  [2]int32 // 系数绝对值上限，保证反量化结果不超出 int16
+}
+
+func newQuantMatrix(dc, ac uint16, biasDC, biasAC int32) quantMatrix {
+	m := quantMatrix{q: [2]int32{int32(dc), int32(ac)}, bias: [2]int32{biasDC, biasAC}}
+	for i, q := range m.q {
+		m.max[i] = min(2047, math.MaxInt16/q)
+	}
+	return m
+}
+
+// quantize 从 first 开始按 zigzag 顺序量化 in，levels 为扫描顺序的量化值，
+// deq 为光栅顺序的反量化结果
+func (m *quantMatrix) quantize(in *[16]int32, first int, levels *[16]int16, deq *[16]int32) {
+	*levels = [16]int16{}
+	*deq = [16]int32{}
+	for n := first; n < 16; n++ {
+		z := zigzag[n]
+		i := min(z, 1)
+		c := in[z]
+		level := (abs(c)*256 + m.bias[i]*m.q[i]) / (256 * m.q[i])
+		level = min(level, m.max[i])
+		if c < 0 {
+			level = -level
+		}
+		levels[n] = int16(level)
+		deq[z] = level * m.q[i]
+	}
+}
+
+type macroblock struct {
+	yMode, uvMode uint8
+	skip          bool // 所有系数都为零
+}
+
+type encoder struct {
+	width, height int
+	mbw, mbh      int
+	qIndex        int
+	filter        int // 环路滤波强度
+	y1, y2, uv    quantMatrix
+
+	// 源图像和重建图像，宽高按宏块补齐
+	ySrc, uSrc, vSrc []uint8
+	yRec, uRec, vRec []uint8
+	yStride, cStride int
+
+	mbs []macroblock
+	// 各宏块按 Y2、16 个 Y、4 个 U、4 个 V 的顺序保存量化值，
+	// 每个块先记录到最后一个非零系数为止的长度，再记录各系数
+	coeffs []int16
+}
+
+// Encode 将图片以有损 VP8 编码为 WebP 文件，quality 取值 1-100，透明度会被丢弃
+func Encode(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() > maxDimension || b.Dy() > maxDimension {
+		return ErrDimensions
+	}
+	e := newEncoder(b.Dx(), b.Dy(), quantIndex(quality))
+	e.importImage(img)
+	frame, err := e.encode()
+	if err != nil {
+		return err
+	}
+
+	pad := len(frame) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(frame)+pad))
+	copy(header[8:], "WEBPVP8 ")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(frame)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if pad == 1 {
+		frame = append(frame, 0)
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// quantIndex 将 quality 映射为量化索引，曲线与 libwebp 相同
+func quantIndex(quality int) int {
+	c := float64(min(max(quality, 0), 100)) / 100
+	if c < 0.75 {
+		c = c * 2 / 3
+	} else {
+		c = 2*c - 1
+	}
+	return min(max(int(127*(1-math.Cbrt(c))), 0), 127)
+}
+
+// filterLevel 按量化索引选择环路滤波强度，量化越粗滤波越强
+func filterLevel(q int) int {
+	return min(q/2, 63)
+}
+
+func newEncoder(width, height, q int) *encoder {
+	e := &encoder{
+		width:  width,
+		height: height,
+		mbw:    (width + 15) >> 4,
+		mbh:    (height + 15) >> 4,
+		qIndex: q,
+		filter: filterLevel(q),
+	}
+	// 偏移值与 libwebp 相同
+	e.y1 = newQuantMatrix(dequantTableDC[q], dequantTableAC[q], 96, 110)
+	e.y2 = newQuantMatrix(dequantTableDC[q]*2, max(dequantTableAC[q]*155/100, 8), 96, 108)
+	e.uv = newQuantMatrix(dequantTableDC[min(q, 117)], dequantTableAC[q], 110, 115)
+
+	e.yStride, e.cStride = e.mbw*16, e.mbw*8
+	ySize, cSize := e.yStride*e.mbh*16, e.cStride*e.mbh*8
+	e.ySrc, e.yRec = make([]uint8, ySize), make([]uint8, ySize)
+	e.uSrc, e.uRec = make([]uint8, cSize), make([]uint8, cSize)
+	e.vSrc, e.vRec = make([]uint8, cSize), make([]uint8, cSize)
+	e.mbs = make([]macroblock, e.mbw*e.mbh)
+	return e
+}
+
+// importImage 将图片转换为 BT.601 有限范围的 YUV 4:2:0，右侧和底部用边缘像素补齐
+func (e *encoder) importImage(img image.Image) {
+	b := img.Bounds()
+	w, h := e.width, e.height
+	cw, ch := (w+1)/2, (h+1)/2
+	strip := image.NewRGBA(image.Rect(0, 0, w, 16))
+	for y0 := 0; y0 < h; y0 += 16 {
+		rows := min(16, h-y0)
+		draw.Draw(strip, image.Rect(0, 0, w, rows), img, image.Pt(b.Min.X, b.Min.Y+y0), draw.Src)
+		for j := 0; j < rows; j++ {
+			pix := strip.Pix[j*strip.Stride:]
+			row := e.ySrc[(y0+j)*e.yStride:]
+			for x := 0; x < w; x++ {
+				r, g, b := int32(pix[4*x]), int32(pix[4*x+1]), int32(pix[4*x+2])
+				row[x] = uint8((16839*r + 33059*g + 6420*b + 1<<15 + 16<<16) >> 16)
+			}
+		}
+		for j := 0; j < rows; j += 2 {
+			p0 := strip.Pix[j*strip.Stride:]
+			p1 := strip.Pix[min(j+1, rows-1)*strip.Stride:]
+			u := e.uSrc[(y0+j)/2*e.cStride:]
+			v := e.vSrc[(y0+j)/2*e.cStride:]
+			for cx := 0; cx < cw; cx++ {
+				i0, i1 := 8*cx, 4*min(2*cx+1, w-1)
+				r := int32(p0[i0]) + int32(p0[i1]) + int32(p1[i0]) + int32(p1[i1])
+				g := int32(p0[i0+1]) + int32(p0[i1+1]) + int32(p1[i0+1]) + int32(p1[i1+1])
+				b := int32(p0[i0+2]) + int32(p0[i1+2]) + int32(p1[i0+2]) + int32(p1[i1+2])
+				u[cx] = clip8((-9719*r - 19081*g + 28800*b + 128<<18 + 1<<17) >> 18)
+				v[cx] = clip8((28800*r - 24116*g - 4684*b + 128<<18 + 1<<17) >> 18)
+			}
+		}
+	}
+	pad(e.ySrc, e.yStride, w, h)
+	pad(e.uSrc, e.cStride, cw, ch)
+	pad(e.vSrc, e.cStride, cw, ch)
+}
+
+// pad 用第 w-1 列和第 h-1 行填充平面的其余部分
+func pad(plane []uint8, stride, w, h int) {
+	for y := 0; y < h; y++ {
+		row := plane[y*stride : (y+1)*stride]
+		for x := w; x < stride; x++ {
+			row[x] = row[w-1]
+		}
+	}
+	last := plane[(h-1)*stride : h*stride]
+	for y := h; y*stride < len(plane); y++ {
+		copy(plane[y*stride:], last)
+	}
+}
+
+// encode 编码所有宏块并生成 VP8 帧
+func (e *encoder) encode() ([]byte, error) {
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	return e.frame()
+}
+
+func (e *encoder) encodeMacroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+	start := len(e.coeffs)
+	mb.yMode = e.encodeLuma(mbx, mby)
+	mb.uvMode = e.encodeChroma(mbx, mby)
+	// 没有非零系数时每个块只记录了长度 0
+	mb.skip = len(e.coeffs)-start == 25
+}
+
+// encodeLuma 选择 16x16 预测模式并量化亮度残差，重建结果写入 yRec
+func (e *encoder) encodeLuma(mbx, mby int) uint8 {
+	x0, y0 := mbx*16, mby*16
+	var src [256]uint8
+	for j := 0; j < 16; j++ {
+		copy(src[j*16:j*16+16], e.ySrc[(y0+j)*e.yStride+x0:])
+	}
+	var top [17]uint8
+	var left [16]uint8
+	edges(e.yRec, e.yStride, x0, y0, top[:], left[:])
+
+	var pred, best [256]uint8
+	mode, bestErr := uint8(0), int64(math.MaxInt64)
+	for m := uint8(0); m < nPred; m++ {
+		predict(m, top[:], left[:], mbx > 0, mby > 0, pred[:], 16)
+		if err := sse(src[:], pred[:]); err < bestErr {
+			mode, bestErr, best = m, err, pred
+		}
+	}
+
+	var coeffs [16][16]int32
+	var dc [16]int32
+	for n := range coeffs {
+		off := n/4*64 + n%4*4
+		fdct(src[off:], best[off:], 16, &coeffs[n])
+		dc[n] = coeffs[n][0]
+	}
+	var y2 [16]int32
+	fwht(&dc, &y2)
+
+	var levels [16]int16
+	var deq [16]int32
+	e.y2.quantize(&y2, 0, &levels, &deq)
+	e.store(&levels)
+	iwht(&deq, &dc)
+	for n := range coeffs {
+		e.y1.quantize(&coeffs[n], 1, &levels, &deq)
+		e.store(&levels)
+		deq[0] = dc[n]
+		idctAdd(&deq, best[n/4*64+n%4*4:], 16)
+	}
+	for j := 0; j < 16; j++ {
+		copy(e.yRec[(y0+j)*e.yStride+x0:], best[j*16:j*16+16])
+	}
+	return mode
+}
+
+// encodeChroma 选择 8x8 预测模式并量化色度残差，重建结果写入 uRec、vRec
+func (e *encoder) encodeChroma(mbx, mby int) uint8 {
+	x0, y0 := mbx*8, mby*8
+	planes := [2]struct {
+		src, rec       []uint8
+		in, pred, best [64]uint8
+		top            [9]uint8
+		left           [8]uint8
+	}{{src: e.uSrc, rec: e.uRec}, {src: e.vSrc, rec: e.vRec}}
+	for i := range planes {
+		p := &planes[i]
+		for j := 0; j < 8; j++ {
+			copy(p.in[j*8:j*8+8], p.src[(y0+j)*e.cStride+x0:])
+		}
+		edges(p.rec, e.cStride, x0, y0, p.top[:], p.left[:])
+	}
+
+	mode, bestErr := uint8(0), int64(math.MaxInt64)
+	for m := uint8(0); m < nPred; m++ {
+		var err int64
+		for i := range planes {
+			p := &planes[i]
+			predict(m, p.top[:], p.left[:], mbx > 0, mby > 0, p.pred[:], 8)
+			err += sse(p.in[:], p.pred[:])
+		}
+		if err < bestErr {
+			mode, bestErr = m, err
+			for i := range planes {
+				planes[i].best = planes[i].pred
+			}
+		}
+	}
+
+	var coeffs [16]int32
+	var levels [16]int16
+	var deq [16]int32
+	for i := range planes {
+		p := &planes[i]
+		for n := 0; n < 4; n++ {
+			off := n/2*32 + n%2*4
+			fdct(p.in[off:], p.best[off:], 8, &coeffs)
+			e.uv.quantize(&coeffs, 0, &levels, &deq)
+			e.store(&levels)
+			idctAdd(&deq, p.best[off:], 8)
+		}
+		for j := 0; j < 8; j++ {
+			copy(p.rec[(y0+j)*e.cStride+x0:], p.best[j*8:j*8+8])
+		}
+	}
+	return mode
+}
+
+// store 保存一个块到最后一个非零系数为止的量化值
+func (e *encoder) store(levels *[16]int16) {
+	n := 16
+	for n > 0 && levels[n-1] == 0 {
+		n--
+	}
+	e.coeffs = append(e.coeffs, int16(n))
+	e.coeffs = append(e.coeffs, levels[:n]...)
+}
+
+// edges 取出预测用的上方（top[0] 为左上角）和左侧重建像素，
+// 图片边缘外的像素按解码器的约定取 127 和 129
+func edges(rec []uint8, stride, x0, y0 int, top, left []uint8) {
+	size := len(left)
+	if y0 == 0 {
+		for i := range top {
+			top[i] = 0x7f
+		}
+	} else {
+		row := rec[(y0-1)*stride:]
+		top[0] = 0x81
+		if x0 > 0 {
+			top[0] = row[x0-1]
+		}
+		copy(top[1:], row[x0:x0+size])
+	}
+	for j := range left {
+		left[j] = 0x81
+		if x0 > 0 {
+			left[j] = rec[(y0+j)*stride+x0-1]
+		}
+	}
+}
+
+// predict 按模式生成 size×size 的预测块
+func predict(mode uint8, top, left []uint8, hasLeft, hasTop bool, out []uint8, size int) {
+	switch mode {
+	case predDC:
+		sum, n := 0, 0
+		if hasTop {
+			for _, v := range top[1:] {
+				sum += int(v)
+			}
+			n += size
+		}
+		if hasLeft {
+			for _, v := range left {
+				sum += int(v)
+			}
+			n += size
+		}
+		dc := uint8(0x80)
+		if n > 0 {
+			dc = uint8((sum + n/2) / n)
+		}
+		for i := range out[:size*size] {
+			out[i] = dc
+		}
+	case predVE:
+		for j := 0; j < size; j++ {
+			copy(out[j*size:], top[1:size+1])
+		}
+	case predHE:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = left[j]
+			}
+		}
+	case predTM:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = clip8(int32(left[j]) + int32(top[1+i]) - int32(top[0]))
+			}
+		}
+	}
+}
+
+func sse(a, b []uint8) int64 {
+	var sum int64
+	for i := range a {
+		d := int64(a[i]) - int64(b[i])
+		sum += d * d
+	}
+	return sum
+}
+
+// fdct 对 4x4 残差做正向 DCT，与 libwebp 的实现一致
+func fdct(src, pred []uint8, stride int, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		s, p := src[i*stride:], pred[i*stride:]
+		d0 := int32(s[0]) - int32(p[0])
+		d1 := int32(s[1]) - int32(p[1])
+		d2 := int32(s[2]) - int32(p[2])
+		d3 := int32(s[3]) - int32(p[3])
+		a0, a1, a2, a3 := d0+d3, d1+d2, d1-d2, d0-d3
+		tmp[i*4+0] = (a0 + a1) * 8
+		tmp[i*4+1] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[i*4+2] = (a0 - a1) * 8
+		tmp[i*4+3] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[12+i]
+		a1 := tmp[4+i] + tmp[8+i]
+		a2 := tmp[4+i] - tmp[8+i]
+		a3 := tmp[0+i] - tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// idctAdd 将反向 DCT 的结果加到 dst 上，与解码器的实现逐位一致
+func idctAdd(c *[16]int32, dst []uint8, stride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := c[i] + c[8+i]
+		b := c[i] - c[8+i]
+		cc := (c[4+i]*c2)>>16 - (c[12+i]*c1)>>16
+		d := (c[4+i]*c1)>>16 + (c[12+i]*c2)>>16
+		m[i] = [4]int32{a + d, b + cc, b - cc, a - d}
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		cc := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride:]
+		row[0] = clip8(int32(row[0]) + (a+d)>>3)
+		row[1] = clip8(int32(row[1]) + (b+cc)>>3)
+		row[2] = clip8(int32(row[2]) + (b-cc)>>3)
+		row[3] = clip8(int32(row[3]) + (a-d)>>3)
+	}
+}
+
+// fwht 对 16 个 DC 系数做正向 Walsh-Hadamard 变换
+func fwht(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[4*i+0] + in[4*i+2]
+		a1 := in[4*i+1] + in[4*i+3]
+		a2 := in[4*i+1] - in[4*i+3]
+		a3 := in[4*i+0] - in[4*i+2]
+		tmp[4*i+0] = a0 + a1
+		tmp[4*i+1] = a3 + a2
+		tmp[4*i+2] = a3 - a2
+		tmp[4*i+3] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[8+i]
+		a1 := tmp[4+i] + tmp[12+i]
+		a2 := tmp[4+i] - tmp[12+i]
+		a3 := tmp[0+i] - tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// iwht 反向 Walsh-Hadamard 变换，out 为各 4x4 块的 DC 系数
+func iwht(in, out *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[0+i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[0+i] - in[12+i]
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0 := dc + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := dc - m[3+i*4]
+		out[4*i+0] = (a0 + a1) >> 3
+		out[4*i+1] = (a3 + a2) >> 3
+		out[4*i+2] = (a0 - a1) >> 3
+		out[4*i+3] = (a3 - a2) >> 3
+	}
+}
+
+func clip8(v int32) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,106 @@
+package vp8
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"golang.org/x/image/vp8"
+	"golang.org/x/image/webp"
+)
+
+// testImage 生成带渐变、边缘和噪点的图片
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			noise := uint8(seed >> 28)
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128 + noise, 255}
+			if (x/24+y/24)%2 == 0 {
+				c.B = 40 + noise
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncode(t *testing.T) {
+	// 宽高不是 16 的倍数时需要补齐宏块
+	src := testImage(203, 117)
+	ref := newEncoder(203, 117, 0)
+	ref.importImage(src)
+
+	var prev int
+	for _, quality := range []int{95, 80, 50, 10} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, quality); err != nil {
+			t.Fatal(err)
+		}
+		size := buf.Len()
+		img, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("quality %d: %v", quality, err)
+		}
+		dec := img.(*image.YCbCr)
+		if dec.Rect != src.Rect || dec.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+			t.Fatalf("quality %d: decoded %v %v", quality, dec.Rect, dec.SubsampleRatio)
+		}
+		var sum float64
+		for y := 0; y < 117; y++ {
+			for x := 0; x < 203; x++ {
+				d := float64(ref.ySrc[y*ref.yStride+x]) - float64(dec.Y[y*dec.YStride+x])
+				sum += d * d
+			}
+		}
+		psnr := 10 * math.Log10(255*255*203*117/sum)
+		if min := 20 + float64(quality)/5; psnr < min {
+			t.Errorf("quality %d: PSNR %.1f dB, want >= %.1f", quality, psnr, min)
+		}
+		if prev > 0 && size >= prev {
+			t.Errorf("quality %d: %d bytes, not smaller than %d", quality, size, prev)
+		}
+		prev = size
+	}
+
+	if err := Encode(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, maxDimension+1, 1)), 80); err != ErrDimensions {
+		t.Errorf("oversized image: %v", err)
+	}
+}
+
+// 编码器的重建结果应与解码器逐位一致，否则预测误差会逐个宏块累积
+func TestReconstruction(t *testing.T) {
+	for _, q := range []int{0, 40, 127} {
+		e := newEncoder(75, 41, q)
+		e.filter = 0 // 解码器在整帧重建后才做环路滤波
+		e.importImage(testImage(75, 41))
+		frame, err := e.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := vp8.NewDecoder()
+		d.Init(bytes.NewReader(frame), len(frame))
+		if _, err := d.DecodeFrameHeader(); err != nil {
+			t.Fatal(err)
+		}
+		dec, err := d.DecodeFrame()
+		if err != nil {
+			t.Fatalf("q %d: %v", q, err)
+		}
+		for y := 0; y < 41; y++ {
+			for x := 0; x < 75; x++ {
+				if got, want := dec.Y[y*dec.YStride+x], e.yRec[y*e.yStride+x]; got != want {
+					t.Fatalf("q %d: Y(%d, %d) = %d, want %d", q, x, y, got, want)
+				}
+				c := y/2*dec.CStride + x/2
+				if dec.Cb[c] != e.uRec[y/2*e.cStride+x/2] || dec.Cr[c] != e.vRec[y/2*e.cStride+x/2] {
+					t.Fatalf("q %d: chroma (%d, %d) mismatch", q, x/2, y/2)
+				}
+			}
+		}
+	}
+}
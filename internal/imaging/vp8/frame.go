@@ -0,0 +1,274 @@
+package vp8
+
+import "math"
+
+type tokenProbs = [nPlane][nBand][nContext][nProb]uint8
+
+// tokenWriter 写入系数，w 为 nil 时只统计各概率位置上 0 和 1 出现的次数
+type tokenWriter struct {
+	w     *boolWriter
+	prob  *tokenProbs
+	stats *[nPlane][nBand][nContext][nProb][2]uint32
+}
+
+func (t *tokenWriter) put(bit bool, plane int, band uint8, ctx uint8, i int) {
+	if t.w == nil {
+		if bit {
+			t.stats[plane][band][ctx][i][1]++
+		} else {
+			t.stats[plane][band][ctx][i][0]++
+		}
+		return
+	}
+	t.w.put(bit, t.prob[plane][band][ctx][i])
+}
+
+// putFixed 写入使用固定概率的位
+func (t *tokenWriter) putFixed(bit bool, prob uint8) {
+	if t.w != nil {
+		t.w.put(bit, prob)
+	}
+}
+
+// block 写入一个 4x4 块的系数，levels 为到最后一个非零系数为止的扫描顺序量化值，
+// 返回块内是否有非零系数，见 RFC 6386 13
+func (t *tokenWriter) block(plane int, ctx uint8, levels []int16, first int) uint8 {
+	n := first
+	band := bands[n]
+	if len(levels) <= first {
+		t.put(false, plane, band, ctx, 0)
+		return 0
+	}
+	t.put(true, plane, band, ctx, 0)
+	for {
+		v := int32(levels[n])
+		n++
+		if v == 0 {
+			t.put(false, plane, band, ctx, 1)
+			band, ctx = bands[n], 0
+			continue
+		}
+		t.put(true, plane, band, ctx, 1)
+		a := abs(v)
+		next := uint8(2)
+		switch {
+		case a == 1:
+			t.put(false, plane, band, ctx, 2)
+			next = 1
+		case a <= 4:
+			t.put(true, plane, band, ctx, 2)
+			t.put(false, plane, band, ctx, 3)
+			if a == 2 {
+				t.put(false, plane, band, ctx, 4)
+			} else {
+				t.put(true, plane, band, ctx, 4)
+				t.put(a == 4, plane, band, ctx, 5)
+			}
+		case a <= 10:
+			t.put(true, plane, band, ctx, 2)
+			t.put(true, plane, band, ctx, 3)
+			t.put(false, plane, band, ctx, 6)
+			if a <= 6 {
+				t.put(false, plane, band, ctx, 7)
+				t.putFixed(a == 6, 159)
+			} else {
+				t.put(true, plane, band, ctx, 7)
+				t.putFixed((a-7)>>1 == 1, 165)
+				t.putFixed((a-7)&1 == 1, 145)
+			}
+		default:
+			t.put(true, plane, band, ctx, 2)
+			t.put(true, plane, band, ctx, 3)
+			t.put(true, plane, band, ctx, 6)
+			cat := 0
+			for a > 3+8<<(cat+1)-1 && cat < 3 {
+				cat++
+			}
+			t.put(cat>>1 == 1, plane, band, ctx, 8)
+			t.put(cat&1 == 1, plane, band, ctx, 9+cat>>1)
+			extra := a - (3 + 8<<cat)
+			tab := cat3456[cat]
+			for i, p := range tab {
+				t.putFixed(extra>>(len(tab)-1-i)&1 == 1, p)
+			}
+		}
+		t.putFixed(v < 0, 128)
+		if n == 16 {
+			return 1
+		}
+		band, ctx = bands[n], next
+		if n == len(levels) {
+			t.put(false, plane, band, ctx, 0)
+			return 1
+		}
+		t.put(true, plane, band, ctx, 0)
+	}
+}
+
+// writeTokens 按解码顺序写入所有宏块的系数，skip 为 true 时跳过没有非零系数的宏块
+func (e *encoder) writeTokens(t *tokenWriter, skip bool) {
+	type context struct {
+		y2   uint8
+		y    [4]uint8
+		u, v [2]uint8
+	}
+	up := make([]context, e.mbw)
+	coeffs := e.coeffs
+	next := func() []int16 {
+		n := int(coeffs[0])
+		levels := coeffs[1 : 1+n]
+		coeffs = coeffs[1+n:]
+		return levels
+	}
+	for mby := 0; mby < e.mbh; mby++ {
+		var left context
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			above := &up[mbx]
+			if skip && e.mbs[mby*e.mbw+mbx].skip {
+				coeffs = coeffs[25:]
+				left, *above = context{}, context{}
+				continue
+			}
+			nz := t.block(planeY2, left.y2+above.y2, next(), 0)
+			left.y2, above.y2 = nz, nz
+			for y := 0; y < 4; y++ {
+				nz := left.y[y]
+				for x := 0; x < 4; x++ {
+					nz = t.block(planeY1WithY2, nz+above.y[x], next(), 1)
+					above.y[x] = nz
+				}
+				left.y[y] = nz
+			}
+			for _, c := range [2]struct{ left, above *[2]uint8 }{{&left.u, &above.u}, {&left.v, &above.v}} {
+				for y := 0; y < 2; y++ {
+					nz := c.left[y]
+					for x := 0; x < 2; x++ {
+						nz = t.block(planeUV, nz+c.above[x], next(), 0)
+						c.above[x] = nz
+					}
+					c.left[y] = nz
+				}
+			}
+		}
+	}
+}
+
+// bitCost 以 prob 编码一位的代价，单位为位
+func bitCost(bit bool, prob uint8) float64 {
+	p := float64(prob) / 256
+	if bit {
+		p = 1 - p
+	}
+	return -math.Log2(p)
+}
+
+// probFor 按统计结果计算取 0 的概率
+func probFor(zeros, total uint32) uint8 {
+	return uint8(min(max(uint64(zeros)*256/uint64(total), 1), 255))
+}
+
+// frame 生成 VP8 关键帧：帧头、第一分区（帧参数和预测模式）和系数分区
+func (e *encoder) frame() ([]byte, error) {
+	var skipped int
+	for _, mb := range e.mbs {
+		if mb.skip {
+			skipped++
+		}
+	}
+	useSkip := skipped > 0
+	skipProb := probFor(uint32(len(e.mbs)-skipped), uint32(len(e.mbs)))
+
+	// 统计系数，代价更低时更新系数概率
+	var stats [nPlane][nBand][nContext][nProb][2]uint32
+	e.writeTokens(&tokenWriter{prob: &defaultTokenProb, stats: &stats}, useSkip)
+	probs := defaultTokenProb
+	var update [nPlane][nBand][nContext][nProb]bool
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l, old := range probs[i][j][k] {
+					c := stats[i][j][k][l]
+					if c[0]+c[1] == 0 {
+						continue
+					}
+					p := probFor(c[0], c[0]+c[1])
+					up := tokenProbUpdateProb[i][j][k][l]
+					oldCost := float64(c[0])*bitCost(false, old) + float64(c[1])*bitCost(true, old)
+					newCost := float64(c[0])*bitCost(false, p) + float64(c[1])*bitCost(true, p) +
+						8 + bitCost(true, up) - bitCost(false, up)
+					if newCost < oldCost {
+						probs[i][j][k][l], update[i][j][k][l] = p, true
+					}
+				}
+			}
+		}
+	}
+
+	fp := newBoolWriter()
+	fp.putLiteral(0, 1) // 色彩空间
+	fp.putLiteral(0, 1) // 需要截断像素值
+	fp.putLiteral(0, 1) // 不分段
+	fp.putLiteral(0, 1) // 普通环路滤波
+	fp.putLiteral(uint32(e.filter), 6)
+	fp.putLiteral(0, 3) // sharpness
+	fp.putLiteral(0, 1) // 不按模式调整滤波强度
+	fp.putLiteral(0, 2) // 一个系数分区
+	fp.putLiteral(uint32(e.qIndex), 7)
+	fp.putLiteral(0, 5) // 各类系数的量化索引都不偏移
+	fp.putLiteral(0, 1) // refresh_entropy_probs
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l, p := range probs[i][j][k] {
+					u := update[i][j][k][l]
+					fp.put(u, tokenProbUpdateProb[i][j][k][l])
+					if u {
+						fp.putLiteral(uint32(p), 8)
+					}
+				}
+			}
+		}
+	}
+	if useSkip {
+		fp.putLiteral(1, 1)
+		fp.putLiteral(uint32(skipProb), 8)
+	} else {
+		fp.putLiteral(0, 1)
+	}
+	for _, mb := range e.mbs {
+		if useSkip {
+			fp.put(mb.skip, skipProb)
+		}
+		// 16x16 亮度预测，模式树见 RFC 6386 11.2
+		fp.put(true, 145)
+		fp.put(mb.yMode >= predHE, 156)
+		if mb.yMode < predHE {
+			fp.put(mb.yMode == predVE, 163)
+		} else {
+			fp.put(mb.yMode == predTM, 128)
+		}
+		fp.put(mb.uvMode != predDC, 142)
+		if mb.uvMode != predDC {
+			fp.put(mb.uvMode != predVE, 114)
+			if mb.uvMode != predVE {
+				fp.put(mb.uvMode == predTM, 183)
+			}
+		}
+	}
+	first := fp.flush()
+	if len(first) >= 1<<19 {
+		return nil, ErrDimensions
+	}
+
+	tw := newBoolWriter()
+	e.writeTokens(&tokenWriter{w: tw, prob: &probs}, useSkip)
+	tokens := tw.flush()
+
+	w, h := e.width, e.height
+	tag := uint32(len(first))<<5 | 1<<4 // 关键帧，版本 0，显示
+	out := make([]byte, 0, 10+len(first)+len(tokens))
+	out = append(out, byte(tag), byte(tag>>8), byte(tag>>16), 0x9d, 0x01, 0x2a,
+		byte(w), byte(w>>8), byte(h), byte(h>>8))
+	out = append(out, first...)
+	return append(out, tokens...), nil
+}
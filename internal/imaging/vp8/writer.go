@@ -0,0 +1,62 @@
+package vp8
+
+import "math/bits"
+
+// boolWriter VP8 的布尔算术编码器，见 RFC 6386 7.3
+type boolWriter struct {
+	buf   []byte
+	rng   uint32
+	low   uint32
+	count int
+}
+
+func newBoolWriter() *boolWriter {
+	return &boolWriter{rng: 255, count: -24}
+}
+
+// put 以 prob/256 为取 0 的概率写入一位
+func (w *boolWriter) put(bit bool, prob uint8) {
+	split := 1 + (w.rng-1)*uint32(prob)>>8
+	if bit {
+		w.low += split
+		w.rng -= split
+	} else {
+		w.rng = split
+	}
+	shift := bits.LeadingZeros8(uint8(w.rng))
+	w.rng <<= shift
+	w.count += shift
+	if w.count >= 0 {
+		offset := shift - w.count
+		if (w.low<<(offset-1))&0x80000000 != 0 {
+			// 向已输出的字节进位
+			i := len(w.buf) - 1
+			for i >= 0 && w.buf[i] == 0xff {
+				w.buf[i] = 0
+				i--
+			}
+			w.buf[i]++
+		}
+		w.buf = append(w.buf, byte(w.low>>(24-offset)))
+		w.low <<= offset
+		shift = w.count
+		w.low &= 0xffffff
+		w.count -= 8
+	}
+	w.low <<= shift
+}
+
+// putLiteral 从高位开始写入 n 位无符号整数
+func (w *boolWriter) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.put(v>>i&1 == 1, 128)
+	}
+}
+
+// flush 输出剩余的位并返回编码结果
+func (w *boolWriter) flush() []byte {
+	for i := 0; i < 32; i++ {
+		w.put(false, 128)
+	}
+	return w.buf
+}
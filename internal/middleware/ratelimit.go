@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"hosting/internal/global"
+	"hosting/internal/utils"
+)
+
+// limiter 未启用限流时为 nil
+var limiter *rateLimiter
+
+// rateLimiter 按客户端地址在固定时间窗口内计数
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// InitRateLimit 根据 security.rateLimit 配置启用限流
+func InitRateLimit() {
+	cfg := global.AppConfig.Security.RateLimit
+	if !cfg.Enabled {
+		return
+	}
+	limit := 60
+	if cfg.Limit > 0 {
+		limit = cfg.Limit
+	}
+	window := time.Minute
+	if cfg.Window != "" {
+		d, err := time.ParseDuration(cfg.Window)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid rate limit window:", cfg.Window)
+		}
+		window = d
+	}
+	limiter = &rateLimiter{limit: limit, window: window, windows: make(map[string]*rateWindow)}
+	go limiter.janitor()
+}
+
+// RateLimit 限制同一地址在时间窗口内的请求数，超出时返回 429，未启用限流时直接处理
+func RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil {
+			if wait, ok := limiter.allow(utils.ClientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// allow 记录一次请求，超出限制时返回距离窗口结束的时间
+func (l *rateLimiter) allow(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	win := l.windows[key]
+	if win == nil || now.Sub(win.start) >= l.window {
+		win = &rateWindow{start: now}
+		l.windows[key] = win
+	}
+	if win.count >= l.limit {
+		return win.start.Add(l.window).Sub(now), false
+	}
+	win.count++
+	return 0, true
+}
+
+// janitor 定期删除已结束的窗口
+func (l *rateLimiter) janitor() {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		now := time.Now()
+		for key, win := range l.windows {
+			if now.Sub(win.start) >= l.window {
+				delete(l.windows, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
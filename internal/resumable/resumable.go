@@ -0,0 +1,415 @@
+package resumable
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"hosting/internal/global"
+)
+
+var (
+	ErrNotFound = errors.New("resumable: upload not found")
+	ErrFull     = errors.New("resumable: too many unfinished uploads")
+	ErrTooMany  = errors.New("resumable: too many unfinished uploads from this address")
+)
+
+// Default 全局断点续传存储
+var Default *Store
+
+// Upload 一次断点续传上传的状态，保存在 <id>.info，已接收的内容保存在 <id>.bin
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata,omitempty"` // 客户端通过 Upload-Metadata 提交的信息
+	IPAddress string            `json:"ipAddress"`
+	UserAgent string            `json:"userAgent"`
+	APIKeyID  int64             `json:"apiKeyId,omitempty"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+
+	// 接收完成并保存后写入，二者只有一个不为空
+	Result *Result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+
+	Offset int64 `json:"-"` // 已接收的字节数，即 .bin 的大小
+}
+
+// Result 保存成功的文件
+type Result struct {
+	ImageID  int64  `json:"imageId"`
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	IsImage  bool   `json:"isImage"`
+}
+
+// Done 判断上传是否已处理完毕
+func (u *Upload) Done() bool {
+	return u.Result != nil || u.Error != ""
+}
+
+// Store 保存未完成上传的目录，超过有效期没有继续的上传由 janitor 删除。
+// 容量按实际接收的字节数计算，声明的长度不占用容量
+type Store struct {
+	dir      string
+	maxBytes int64
+	maxPerIP int // 同一地址未完成上传的最大数量
+	expiry   time.Duration
+
+	mu       sync.Mutex
+	locked   map[string]bool
+	active   map[string]*activeUpload // 未完成的上传，启动时从目录重建
+	received int64                    // 未完成上传已接收和正在写入的字节数
+	sessions map[string]int           // 各地址未完成的上传数量
+}
+
+// activeUpload 计算容量时需要的未完成上传信息
+type activeUpload struct {
+	ipAddress string
+	size      int64 // 已接收的字节数
+}
+
+// InitResumable 根据配置创建断点续传存储
+func InitResumable() {
+	cfg := global.AppConfig.Resumable
+
+	dir := cfg.Path
+	if dir == "" {
+		dir = "./uploads"
+	}
+	maxSize := 1024
+	if cfg.MaxSize > 0 {
+		maxSize = cfg.MaxSize
+	}
+	maxPerIP := 10
+	if cfg.MaxPerIP > 0 {
+		maxPerIP = cfg.MaxPerIP
+	}
+	expiry := 24 * time.Hour
+	if cfg.Expiry != "" {
+		d, err := time.ParseDuration(cfg.Expiry)
+		if err != nil {
+			log.Fatal("Invalid resumable expiry:", err)
+		}
+		expiry = d
+	}
+
+	var err error
+	Default, err = New(dir, int64(maxSize)*1024*1024, maxPerIP, expiry)
+	if err != nil {
+		log.Fatal("Failed to initialize resumable uploads:", err)
+	}
+	go Default.janitor(10 * time.Minute)
+}
+
+// New 创建断点续传存储
+func New(dir string, maxBytes int64, maxPerIP int, expiry time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// 删除上次退出时未写完的状态文件
+	if tmp, err := filepath.Glob(filepath.Join(dir, ".tmp-*")); err == nil {
+		for _, name := range tmp {
+			os.Remove(name)
+		}
+	}
+	s := &Store{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxPerIP: maxPerIP,
+		expiry:   expiry,
+		locked:   make(map[string]bool),
+		active:   make(map[string]*activeUpload),
+		sessions: make(map[string]int),
+	}
+	// 统计上次退出时未完成的上传，之后只在内存中更新
+	err := s.each(func(u *Upload) {
+		if u.Done() {
+			return
+		}
+		var size int64
+		if info, err := os.Stat(s.path(u.ID, ".bin")); err == nil {
+			size = info.Size()
+		}
+		s.track(u.ID, u.IPAddress, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create 创建新的上传。已接收的内容占满容量时返回 ErrFull，
+// 同一地址未完成的上传过多时返回 ErrTooMany
+func (s *Store) Create(u *Upload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.received >= s.maxBytes {
+		return ErrFull
+	}
+	if s.sessions[u.IPAddress] >= s.maxPerIP {
+		return ErrTooMany
+	}
+
+	u.ID = uuid.New().String()
+	u.ExpiresAt = time.Now().Add(s.expiry)
+	f, err := os.OpenFile(s.path(u.ID, ".bin"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := s.save(u); err != nil {
+		os.Remove(s.path(u.ID, ".bin"))
+		return err
+	}
+	s.track(u.ID, u.IPAddress, 0)
+	return nil
+}
+
+// Get 读取上传状态，不存在或已过期时返回 ErrNotFound
+func (s *Store) Get(id string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id, ".info"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	if u.Done() {
+		u.Offset = u.Length
+		return &u, nil
+	}
+	info, err := os.Stat(s.path(id, ".bin"))
+	if err != nil {
+		return nil, err
+	}
+	u.Offset = info.Size()
+	return &u, nil
+}
+
+// Lock 独占一个上传，同一上传同时只处理一个请求，已被占用时返回 false
+func (s *Store) Lock(id string) (unlock func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return nil, false
+	}
+	s.locked[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.locked, id)
+		s.mu.Unlock()
+	}, true
+}
+
+// Append 在已接收的内容后追加数据，最多写到 Length 为止，容量不足时只写入剩余容量并返回 ErrFull。
+// 读取中断时已写入的部分仍然保留，客户端可以从新的 Offset 继续。
+func (s *Store) Append(u *Upload, r io.Reader) error {
+	limit, err := s.reserve(u.Length - u.Offset)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	defer func() { s.release(u.ID, limit, n) }()
+
+	f, err := os.OpenFile(s.path(u.ID, ".bin"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, limit))
+	u.Offset += n
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+
+	// 有进展时延长有效期
+	if n > 0 {
+		u.ExpiresAt = time.Now().Add(s.expiry)
+		if err := s.save(u); copyErr == nil {
+			copyErr = err
+		}
+	}
+	if copyErr == nil && u.Offset < u.Length && n == limit {
+		copyErr = ErrFull
+	}
+	return copyErr
+}
+
+// reserve 为一次写入预留容量，返回本次最多可以写入的字节数，容量已满时返回 ErrFull
+func (s *Store) reserve(want int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := min(want, s.maxBytes-s.received)
+	if want > 0 && n <= 0 {
+		return 0, ErrFull
+	}
+	n = max(n, 0)
+	s.received += n
+	return n, nil
+}
+
+// release 写入结束后退还预留但未写入的容量，written 计入该上传
+func (s *Store) release(id string, reserved, written int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received -= reserved - written
+	if a := s.active[id]; a != nil {
+		a.size += written
+	} else {
+		// 写入期间已被删除，内容不再占用容量
+		s.received -= written
+	}
+}
+
+// track 记录未完成的上传，调用方需持有锁或尚未共享 Store
+func (s *Store) track(id, ipAddress string, size int64) {
+	s.active[id] = &activeUpload{ipAddress: ipAddress, size: size}
+	s.received += size
+	s.sessions[ipAddress]++
+}
+
+// untrack 上传完成或删除后释放其占用的容量，调用方需持有锁
+func (s *Store) untrack(id string) {
+	a := s.active[id]
+	if a == nil {
+		return
+	}
+	delete(s.active, id)
+	s.received -= a.size
+	if s.sessions[a.ipAddress]--; s.sessions[a.ipAddress] <= 0 {
+		delete(s.sessions, a.ipAddress)
+	}
+}
+
+// Open 打开已接收的内容
+func (s *Store) Open(u *Upload) (*os.File, error) {
+	return os.Open(s.path(u.ID, ".bin"))
+}
+
+// Finish 记录处理结果并删除已接收的内容，状态保留到有效期结束供客户端查询
+func (s *Store) Finish(u *Upload) error {
+	if err := s.save(u); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(u.ID, ".bin"))
+	s.mu.Lock()
+	s.untrack(u.ID)
+	s.mu.Unlock()
+	return err
+}
+
+// Remove 删除上传
+func (s *Store) Remove(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	os.Remove(s.path(id, ".bin"))
+	err := os.Remove(s.path(id, ".info"))
+	s.mu.Lock()
+	s.untrack(id)
+	s.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// each 遍历目录中的所有上传状态，忽略无法读取的文件
+func (s *Store) each(fn func(u *Upload)) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".info")
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(s.path(id, ".info"))
+		if err != nil {
+			continue
+		}
+		var u Upload
+		if json.Unmarshal(data, &u) == nil {
+			fn(&u)
+		}
+	}
+	return nil
+}
+
+// janitor 定期删除过期的上传
+func (s *Store) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.cleanup()
+	}
+}
+
+func (s *Store) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var expired []string
+	s.each(func(u *Upload) {
+		if now.After(u.ExpiresAt) && !s.locked[u.ID] {
+			expired = append(expired, u.ID)
+		}
+	})
+	for _, id := range expired {
+		os.Remove(s.path(id, ".bin"))
+		os.Remove(s.path(id, ".info"))
+		s.untrack(id)
+	}
+	if len(expired) > 0 {
+		log.Printf("Removed %d expired resumable uploads", len(expired))
+	}
+}
+
+// save 写入上传状态，先写临时文件再重命名，避免中断时留下不完整的文件
+func (s *Store) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(u.ID, ".info"))
+}
+
+func (s *Store) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
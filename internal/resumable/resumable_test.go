@@ -0,0 +1,64 @@
+package resumable
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreUsage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 100, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Upload{Length: 80, IPAddress: "1.2.3.4"}
+	b := &Upload{Length: 80, IPAddress: "1.2.3.4"}
+	for _, u := range []*Upload{a, b} {
+		if err := s.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create(&Upload{Length: 1, IPAddress: "1.2.3.4"}); !errors.Is(err, ErrTooMany) {
+		t.Fatalf("third upload from same address: %v, want ErrTooMany", err)
+	}
+
+	// 只有实际写入的字节占用容量
+	if err := s.Append(a, bytes.NewReader(make([]byte, 60))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(b, bytes.NewReader(make([]byte, 80))); !errors.Is(err, ErrFull) {
+		t.Fatalf("append over capacity: %v, want ErrFull", err)
+	}
+	if b.Offset != 40 {
+		t.Fatalf("offset after partial append = %d, want 40", b.Offset)
+	}
+	if err := s.Create(&Upload{Length: 1, IPAddress: "5.6.7.8"}); !errors.Is(err, ErrFull) {
+		t.Fatalf("create when full: %v, want ErrFull", err)
+	}
+
+	// 重新打开目录时恢复统计
+	s, err = New(dir, 100, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.received != 100 || s.sessions["1.2.3.4"] != 2 {
+		t.Fatalf("after restart received=%d sessions=%d", s.received, s.sessions["1.2.3.4"])
+	}
+
+	// 完成和删除后释放容量和名额
+	if err := s.Finish(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s.received != 0 || len(s.sessions) != 0 {
+		t.Fatalf("after finish received=%d sessions=%v", s.received, s.sessions)
+	}
+	if err := s.Create(&Upload{Length: 1, IPAddress: "1.2.3.4"}); err != nil {
+		t.Fatalf("create after release: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"hosting/internal/global"
+)
+
+// chunkedKeyPrefix 分片文件的 key 前缀，分片清单保存在 file_parts 表
+const chunkedKeyPrefix = "chunked:"
+
+// Chunked 将超过 ChunkSize 的文件拆成多个分片写入内层后端，
+// 读取时按顺序拼接，用于绕过 Telegram getFile 的 20MB 下载限制
+type Chunked struct {
+	Backend
+	ChunkSize int64
+}
+
+func NewChunked(inner Backend, chunkSize int64) *Chunked {
+	return &Chunked{Backend: inner, ChunkSize: chunkSize}
+}
+
+type part struct {
+	Key  string
+	Size int64
+
+	// 内层后端为 Telegram 时删除分片所需的频道消息
+	ChatID    int64
+	MessageID int64
+}
+
+func (c *Chunked) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) (*Object, error) {
+	if size >= 0 && size <= c.ChunkSize {
+		return c.Backend.Put(ctx, name, r, size, contentType)
+	}
+
+	// 支持随机读取时每个分片使用独立的 SectionReader，便于内层后端失败重试
+	var offset int64
+	ra, seekable := r.(io.ReaderAt)
+	if seeker, ok := r.(io.Seeker); seekable && ok && size >= 0 {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		offset = pos
+	} else {
+		seekable = false
+	}
+
+	br := bufio.NewReader(r)
+	var parts []part
+	var first *Object
+	var total int64
+	for i := 0; ; i++ {
+		partSize := c.ChunkSize
+		var partReader io.Reader
+		// 长度未知时通过计数得到分片实际大小
+		counter := &countingReader{}
+		if seekable {
+			if total >= size {
+				break
+			}
+			partSize = min(partSize, size-total)
+			partReader = io.NewSectionReader(ra, offset+total, partSize)
+		} else {
+			if _, err := br.Peek(1); err == io.EOF {
+				break
+			} else if err != nil {
+				c.deleteParts(ctx, parts)
+				return nil, err
+			}
+			if size >= 0 {
+				partSize = min(partSize, size-total)
+			} else {
+				partSize = -1
+			}
+			counter.r = io.LimitReader(br, c.ChunkSize)
+			partReader = counter
+		}
+
+		obj, err := c.Backend.Put(ctx, fmt.Sprintf("%s.part%03d", name, i+1), partReader, partSize, "application/octet-stream")
+		if err != nil {
+			c.deleteParts(ctx, parts)
+			return nil, fmt.Errorf("put part %d: %w", i+1, err)
+		}
+		if first == nil {
+			first = obj
+		}
+		n := partSize
+		if !seekable {
+			n = counter.n
+		}
+		parts = append(parts, part{Key: obj.Key, Size: n, ChatID: obj.ChatID, MessageID: obj.MessageID})
+		total += n
+	}
+
+	if first == nil {
+		return c.Backend.Put(ctx, name, bytes.NewReader(nil), 0, contentType)
+	}
+
+	// 只有一个分片时无需清单
+	obj := &Object{
+		Key:         parts[0].Key,
+		Size:        total,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		BotID:       first.BotID,
+		ChatID:      first.ChatID,
+		MessageID:   first.MessageID,
+	}
+	if len(parts) == 1 {
+		return obj, nil
+	}
+
+	// 各分片的消息记录在清单中
+	obj.Key = chunkedKeyPrefix + uuid.New().String()
+	obj.MessageID = 0
+	if err := saveParts(ctx, obj.Key, parts); err != nil {
+		c.deleteParts(ctx, parts)
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (c *Chunked) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if !strings.HasPrefix(key, chunkedKeyPrefix) {
+		return c.Backend.Get(ctx, key)
+	}
+	parts, err := loadParts(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj := &Object{Key: key}
+	for _, p := range parts {
+		obj.Size += p.Size
+	}
+	return &partsReader{ctx: ctx, backend: c.Backend, parts: parts}, obj, nil
+}
+
+func (c *Chunked) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if !strings.HasPrefix(key, chunkedKeyPrefix) {
+		return GetRange(ctx, c.Backend, key, offset, length)
+	}
+	parts, err := loadParts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// 跳过范围之前的分片
+	for len(parts) > 0 && offset >= parts[0].Size {
+		offset -= parts[0].Size
+		parts = parts[1:]
+	}
+	pr := &partsReader{ctx: ctx, backend: c.Backend, parts: parts, offset: offset}
+	return readCloser{Reader: io.LimitReader(pr, length), Closer: pr}, nil
+}
+
+func (c *Chunked) Stat(ctx context.Context, key string) (*Object, error) {
+	if !strings.HasPrefix(key, chunkedKeyPrefix) {
+		return c.Backend.Stat(ctx, key)
+	}
+	parts, err := loadParts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{Key: key}
+	for _, p := range parts {
+		obj.Size += p.Size
+	}
+	return obj, nil
+}
+
+func (c *Chunked) Delete(ctx context.Context, key string) error {
+	if !strings.HasPrefix(key, chunkedKeyPrefix) {
+		return c.Backend.Delete(ctx, key)
+	}
+	parts, err := loadParts(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := c.Backend.Delete(ctx, p.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	_, err = global.DB.ExecContext(ctx, "DELETE FROM file_parts WHERE file_key = ?", key)
+	return err
+}
+
+// DeleteObject 删除对象，分片文件逐个删除分片后移除清单
+func (c *Chunked) DeleteObject(ctx context.Context, obj *Object) error {
+	if !strings.HasPrefix(obj.Key, chunkedKeyPrefix) {
+		return DeleteObject(ctx, c.Backend, obj)
+	}
+	parts, err := loadParts(ctx, obj.Key)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := DeleteObject(ctx, c.Backend, p.object()); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("delete part %s: %w", p.Key, err)
+		}
+	}
+	_, err = global.DB.ExecContext(ctx, "DELETE FROM file_parts WHERE file_key = ?", obj.Key)
+	return err
+}
+
+// deleteParts 尽力清理写入失败时已上传的分片
+func (c *Chunked) deleteParts(ctx context.Context, parts []part) {
+	for _, p := range parts {
+		DeleteObject(context.WithoutCancel(ctx), c.Backend, p.object())
+	}
+}
+
+func (p part) object() *Object {
+	return &Object{Key: p.Key, Size: p.Size, ChatID: p.ChatID, MessageID: p.MessageID}
+}
+
+func saveParts(ctx context.Context, key string, parts []part) error {
+	tx, err := global.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, p := range parts {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO file_parts (file_key, part_index, part_key, size, chat_id, message_id) VALUES (?, ?, ?, ?, ?, ?)",
+			key, i, p.Key, p.Size, p.ChatID, p.MessageID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func loadParts(ctx context.Context, key string) ([]part, error) {
+	rows, err := global.DB.QueryContext(ctx,
+		"SELECT part_key, size, chat_id, message_id FROM file_parts WHERE file_key = ? ORDER BY part_index", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []part
+	for rows.Next() {
+		var p part
+		if err := rows.Scan(&p.Key, &p.Size, &p.ChatID, &p.MessageID); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, ErrNotFound
+	}
+	return parts, nil
+}
+
+// partsReader 按顺序逐个打开分片，拼接为一个连续的流
+type partsReader struct {
+	ctx     context.Context
+	backend Backend
+	parts   []part
+	offset  int64 // 第一个分片中跳过的字节数
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			var body io.ReadCloser
+			var err error
+			if p.offset > 0 {
+				body, err = GetRange(p.ctx, p.backend, p.parts[0].Key, p.offset, p.parts[0].Size-p.offset)
+				p.offset = 0
+			} else {
+				body, _, err = p.backend.Get(p.ctx, p.parts[0].Key)
+			}
+			if err != nil {
+				return 0, err
+			}
+			p.current = body
+			p.parts = p.parts[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current != nil {
+		return p.current.Close()
+	}
+	return nil
+}
+
+// countingReader 记录读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Options S3 兼容存储的连接参数
+type S3Options struct {
+	Endpoint      string // 如 https://minio.example.com:9000
+	Region        string // 默认 us-east-1，R2 使用 auto
+	Bucket        string
+	Prefix        string // 对象 key 前缀
+	AccessKey     string
+	SecretKey     string
+	VirtualHost   bool          // 使用 bucket.endpoint 形式访问，默认为路径形式
+	Redirect      bool          // 访问文件时 302 跳转到预签名地址
+	PresignExpiry time.Duration // 预签名地址有效期
+}
+
+// S3 使用 AWS Signature V4 访问 S3 兼容的对象存储（MinIO、Ceph RGW、R2 等）
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	Client   *http.Client
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3: endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PresignExpiry <= 0 {
+		opts.PresignExpiry = time.Hour
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	return &S3{opts: opts, endpoint: endpoint, Client: http.DefaultClient}, nil
+}
+
+func (s *S3) Name() string {
+	return "s3"
+}
+
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) (*Object, error) {
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	key := path.Join(s.opts.Prefix, id[0:2], id[2:4], id+strings.ToLower(path.Ext(name)))
+
+	// S3 不接受未知长度的上传，先落盘以获得长度
+	if size < 0 {
+		tmp, err := os.CreateTemp("", "s3-put-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, r); err != nil {
+			return nil, err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r = tmp
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &Object{
+		Key:         key,
+		Size:        size,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+	}, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectFromResponse(key, resp), nil
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return readCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	}
+	return sliceBody(resp.Body, offset, length)
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*Object, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectFromResponse(key, resp), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RedirectURL 在开启 Redirect 时返回预签名地址，否则返回空字符串
+func (s *S3) RedirectURL(ctx context.Context, key string) (string, error) {
+	if !s.opts.Redirect {
+		return "", nil
+	}
+	return s.Presign(http.MethodGet, key, s.opts.PresignExpiry)
+}
+
+// Presign 生成预签名地址
+func (s *S3) Presign(method, key string, expires time.Duration) (string, error) {
+	return s.presignAt(method, key, expires, time.Now().UTC()), nil
+}
+
+func (s *S3) presignAt(method, key string, expires time.Duration, now time.Time) string {
+	u := s.objectURL(key)
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.opts.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(query)
+
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+func (s *S3) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// do 签名并发送请求，非 2xx 响应转换为错误
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	s.sign(req)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// sign 使用请求头方式进行 Signature V4 签名，负载不参与签名
+func (s *S3) sign(req *http.Request) {
+	s.signAt(req, time.Now().UTC())
+}
+
+func (s *S3) signAt(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + unsignedPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonical)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.opts.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	p := "/" + key
+	if s.opts.VirtualHost {
+		u.Host = s.opts.Bucket + "." + u.Host
+	} else {
+		p = "/" + s.opts.Bucket + p
+	}
+	base := strings.TrimRight(u.EscapedPath(), "/")
+	u.Path = strings.TrimRight(u.Path, "/") + p
+	u.RawPath = base + uriEncode(p, false)
+	if u.RawPath == u.Path {
+		u.RawPath = ""
+	}
+	return &u
+}
+
+func objectFromResponse(key string, resp *http.Response) *Object {
+	obj := &Object{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = lm
+	}
+	return obj
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery 按 Signature V4 要求排序并编码查询参数
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 按 RFC 3986 编码，encodeSlash 为 false 时保留路径分隔符
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
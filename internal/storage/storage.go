@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"hosting/internal/global"
+	"hosting/internal/telegram"
+)
+
+var (
+	ErrNotFound     = errors.New("storage: object not found")
+	ErrNotSupported = errors.New("storage: operation not supported")
+)
+
+// Object 描述存储后端中的一个对象
+type Object struct {
+	Key         string // 后端内的定位标识，写入 images.file_id
+	URL         string // 后端可直接访问的地址（可能为空或会过期）
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+
+	// Telegram 写入的 bot、频道和消息，其他后端为 0
+	BotID     int64
+	ChatID    int64
+	MessageID int64
+}
+
+// Backend 存储后端接口，所有读写均为流式
+type Backend interface {
+	// Name 返回后端名称，写入 images.storage
+	Name() string
+	// Put 写入对象，name 为建议的文件名，size 未知时传 -1
+	Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) (*Object, error)
+	// Get 读取对象，调用方负责关闭返回的 ReadCloser
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Stat(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Redirector 可将访问重定向到后端直链的后端
+type Redirector interface {
+	// RedirectURL 返回可供客户端直接访问的地址，返回空字符串表示不重定向
+	RedirectURL(ctx context.Context, key string) (string, error)
+}
+
+// RangeGetter 可只读取对象一部分的后端
+type RangeGetter interface {
+	// GetRange 读取从 offset 开始的 length 字节
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ObjectDeleter 删除对象时需要 key 以外信息的后端，例如 Telegram 需要频道消息 ID
+type ObjectDeleter interface {
+	DeleteObject(ctx context.Context, obj *Object) error
+}
+
+// DeleteObject 删除对象，后端支持时使用 Object 中记录的位置信息
+func DeleteObject(ctx context.Context, b Backend, obj *Object) error {
+	if od, ok := b.(ObjectDeleter); ok {
+		return od.DeleteObject(ctx, obj)
+	}
+	return b.Delete(ctx, obj.Key)
+}
+
+// GetRange 读取对象的一部分，后端不支持时读取完整对象并跳过前面的内容
+func GetRange(ctx context.Context, b Backend, key string, offset, length int64) (io.ReadCloser, error) {
+	if rg, ok := b.(RangeGetter); ok {
+		return rg.GetRange(ctx, key, offset, length)
+	}
+	body, _, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return sliceBody(body, offset, length)
+}
+
+// sliceBody 跳过 body 前 offset 字节并限制读取 length 字节
+func sliceBody(body io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			body.Close()
+			return nil, err
+		}
+	}
+	return readCloser{Reader: io.LimitReader(body, length), Closer: body}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// getHTTPRange 发送带 Range 头的请求，服务端忽略 Range 时自行截取
+func getHTTPRange(client *http.Client, req *http.Request, offset, length int64) (*http.Response, io.ReadCloser, error) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, readCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	case http.StatusOK:
+		body, err := sliceBody(resp.Body, offset, length)
+		return resp, body, err
+	default:
+		return resp, nil, nil
+	}
+}
+
+var (
+	// Primary 新上传文件写入的后端
+	Primary Backend
+	// Replicas 上传时额外写入的副本后端
+	Replicas []Backend
+
+	backends = make(map[string]Backend)
+)
+
+// Register 注册一个后端，重复注册同名后端会覆盖
+func Register(b Backend) {
+	backends[b.Name()] = b
+}
+
+// Lookup 根据名称获取后端，name 为空时视为 telegram（兼容旧记录）
+func Lookup(name string) (Backend, error) {
+	if name == "" {
+		name = "telegram"
+	}
+	b, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("storage: backend %q not configured", name)
+	}
+	return b, nil
+}
+
+// InitStorage 根据配置初始化主存储和副本存储
+func InitStorage() {
+	for _, name := range global.AppConfig.StorageBackends() {
+		b, err := New(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Register(b)
+	}
+
+	var err error
+	Primary, err = Lookup(global.AppConfig.StorageType())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Replicas = nil
+	for _, name := range global.AppConfig.Storage.Replicas {
+		if name == Primary.Name() {
+			continue
+		}
+		b, err := Lookup(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Replicas = append(Replicas, b)
+	}
+}
+
+// NewTelegramWithChats 使用配置中的 bot 创建写入指定频道的 Telegram 后端
+func NewTelegramWithChats(chatIDs []int64) Backend {
+	chunkSize := global.DefaultChunkSize
+	if global.AppConfig.Telegram.ChunkSize > 0 {
+		chunkSize = global.AppConfig.Telegram.ChunkSize
+	}
+	tg := NewTelegram(global.Bots, chatIDs)
+	tg.DefaultBot = global.Bot
+	tg.Placement = global.AppConfig.Telegram.Placement
+	tg.FileEndpoint = telegram.FileEndpoint()
+	return NewChunked(tg, int64(chunkSize)*1024*1024)
+}
+
+// New 根据配置创建指定名称的后端
+func New(name string) (Backend, error) {
+	switch name {
+	case "telegram":
+		return NewTelegramWithChats(global.AppConfig.TelegramChatIDs()), nil
+	case "local":
+		local, err := NewLocal(global.AppConfig.Storage.Local.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local storage directory: %w", err)
+		}
+		return local, nil
+	case "s3":
+		cfg := global.AppConfig.Storage.S3
+		expiry := time.Hour
+		if cfg.PresignExpiry != "" {
+			d, err := time.ParseDuration(cfg.PresignExpiry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid s3 presignExpiry: %w", err)
+			}
+			expiry = d
+		}
+		return NewS3(S3Options{
+			Endpoint:      cfg.Endpoint,
+			Region:        cfg.Region,
+			Bucket:        cfg.Bucket,
+			Prefix:        cfg.Prefix,
+			AccessKey:     cfg.AccessKey,
+			SecretKey:     cfg.SecretKey,
+			VirtualHost:   cfg.VirtualHost,
+			Redirect:      cfg.Redirect,
+			PresignExpiry: expiry,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", name)
+	}
+}
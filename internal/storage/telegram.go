@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"hosting/internal/global"
+)
+
+var ErrNoTarget = errors.New("telegram: no available bot/channel")
+
+// Telegram 将文件以文档形式发送到频道保存。
+// 配置多个 bot 或频道时按 Placement 选择写入目标，失败时依次尝试下一个。
+// 新写入的 key 形如 "<bot_id>:<file_id>"，旧记录只有 file_id，使用 DefaultBot 读取。
+type Telegram struct {
+	Bots       []*tgbotapi.BotAPI
+	DefaultBot *tgbotapi.BotAPI
+	ChatIDs    []int64
+	Placement  string // roundrobin（默认）或 hash
+	Client     *http.Client
+	// FileEndpoint 文件下载地址格式，参数依次为 token 和 file_path
+	FileEndpoint string
+
+	targets []*telegramTarget
+	next    atomic.Uint64
+}
+
+// telegramTarget 一个 bot 和频道的组合
+type telegramTarget struct {
+	bot    *tgbotapi.BotAPI
+	chatID int64
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func NewTelegram(bots []*tgbotapi.BotAPI, chatIDs []int64) *Telegram {
+	t := &Telegram{
+		Bots:         bots,
+		ChatIDs:      chatIDs,
+		Client:       http.DefaultClient,
+		FileEndpoint: tgbotapi.FileEndpoint,
+	}
+	if len(bots) > 0 {
+		t.DefaultBot = bots[0]
+	}
+	for _, chatID := range chatIDs {
+		for _, bot := range bots {
+			t.targets = append(t.targets, &telegramTarget{bot: bot, chatID: chatID})
+		}
+	}
+	return t
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) (*Object, error) {
+	if len(t.targets) == 0 {
+		return nil, ErrNoTarget
+	}
+
+	// 失败重试需要重新读取内容，每次尝试使用独立的 SectionReader
+	open := func() io.Reader { return r }
+	if len(t.targets) > 1 {
+		section, cleanup, err := rereadable(r, size)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		open = func() io.Reader { return io.NewSectionReader(section, 0, section.Size()) }
+	}
+
+	var lastErr error
+	for _, target := range t.order(name) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !target.available() {
+			continue
+		}
+
+		obj, err := t.put(target, name, open(), contentType)
+		if err == nil {
+			return obj, nil
+		}
+		lastErr = err
+		target.markFailed(err)
+		log.Printf("Telegram upload to chat %d via bot %d failed: %v", target.chatID, target.bot.Self.ID, err)
+	}
+	if lastErr == nil {
+		lastErr = ErrNoTarget
+	}
+	return nil, lastErr
+}
+
+func (t *Telegram) put(target *telegramTarget, name string, r io.Reader, contentType string) (*Object, error) {
+	msg := tgbotapi.NewDocument(target.chatID, tgbotapi.FileReader{Name: name, Reader: r})
+	message, err := target.bot.Send(msg)
+	if err != nil {
+		return nil, err
+	}
+	if message.Document == nil {
+		return nil, fmt.Errorf("telegram: message %d has no document", message.MessageID)
+	}
+
+	key := fmt.Sprintf("%d:%s", target.bot.Self.ID, message.Document.FileID)
+	obj := &Object{
+		Key:         key,
+		Size:        int64(message.Document.FileSize),
+		ContentType: contentType,
+		ModTime:     time.Unix(int64(message.Date), 0),
+		BotID:       target.bot.Self.ID,
+		ChatID:      target.chatID,
+		MessageID:   int64(message.MessageID),
+	}
+	// 直链获取失败不影响上传结果，访问时会重新获取
+	if url, err := t.fileURL(key); err == nil {
+		obj.URL = url
+	}
+	return obj, nil
+}
+
+// order 返回本次写入尝试的目标顺序
+func (t *Telegram) order(name string) []*telegramTarget {
+	var start int
+	if t.Placement == "hash" {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		start = int(h.Sum32() % uint32(len(t.targets)))
+	} else {
+		start = int((t.next.Add(1) - 1) % uint64(len(t.targets)))
+	}
+
+	ordered := make([]*telegramTarget, 0, len(t.targets))
+	for i := range t.targets {
+		ordered = append(ordered, t.targets[(start+i)%len(t.targets)])
+	}
+	return ordered
+}
+
+func (t *Telegram) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	url, err := t.fileURL(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 本地模式的 Bot API 服务器返回的是本机绝对路径
+	if filepath.IsAbs(url) {
+		return t.openLocal(key, url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, t.statusError(key, resp)
+	}
+
+	obj := &Object{
+		Key:         key,
+		URL:         url,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = lm
+	}
+	return resp.Body, obj, nil
+}
+
+func (t *Telegram) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	url, err := t.fileURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.IsAbs(url) {
+		body, _, err := t.openLocal(key, url)
+		if err != nil {
+			return nil, err
+		}
+		f := body.(*os.File)
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := getHTTPRange(t.Client, req, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		resp.Body.Close()
+		return nil, t.statusError(key, resp)
+	}
+	return body, nil
+}
+
+// statusError 将下载失败的响应转换为错误，直链可能已过期，清除缓存以便下次重新获取
+func (t *Telegram) statusError(key string, resp *http.Response) error {
+	t.forget(key)
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return fmt.Errorf("telegram: unexpected status %s", resp.Status)
+}
+
+func (t *Telegram) forget(key string) {
+	global.URLCacheMux.Lock()
+	delete(global.URLCache, key)
+	global.URLCacheMux.Unlock()
+}
+
+func (t *Telegram) Stat(ctx context.Context, key string) (*Object, error) {
+	bot, fileID, err := t.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		if strings.Contains(err.Error(), "file not found") || strings.Contains(err.Error(), "wrong file_id") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &Object{
+		Key:   key,
+		URL:   t.link(bot, file),
+		Size:  int64(file.FileSize),
+		BotID: bot.Self.ID,
+	}, nil
+}
+
+// Delete 仅凭 file_id 无法删除频道消息，需要使用 DeleteObject
+func (t *Telegram) Delete(ctx context.Context, key string) error {
+	return ErrNotSupported
+}
+
+// DeleteObject 删除保存文件的频道消息，没有记录消息 ID 的旧文件无法删除
+func (t *Telegram) DeleteObject(ctx context.Context, obj *Object) error {
+	if obj.ChatID == 0 || obj.MessageID == 0 {
+		return ErrNotSupported
+	}
+	bot, _, err := t.resolve(obj.Key)
+	if err != nil {
+		return err
+	}
+	resp, err := bot.Request(tgbotapi.NewDeleteMessage(obj.ChatID, int(obj.MessageID)))
+	if err != nil {
+		// 消息已被删除
+		if strings.Contains(err.Error(), "message to delete not found") {
+			return ErrNotFound
+		}
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("telegram: deleteMessage failed: %s", resp.Description)
+	}
+	t.forget(obj.Key)
+	return nil
+}
+
+// resolve 解析 key 得到对应的 bot 和 file_id
+func (t *Telegram) resolve(key string) (*tgbotapi.BotAPI, string, error) {
+	botPart, fileID, ok := strings.Cut(key, ":")
+	if !ok {
+		if t.DefaultBot == nil {
+			return nil, "", ErrNoTarget
+		}
+		return t.DefaultBot, key, nil
+	}
+
+	botID, err := strconv.ParseInt(botPart, 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("telegram: invalid key %q", key)
+	}
+	for _, bot := range t.Bots {
+		if bot.Self.ID == botID {
+			return bot, fileID, nil
+		}
+	}
+	return nil, "", fmt.Errorf("telegram: bot %d not configured", botID)
+}
+
+// fileURL 获取文件直链，优先使用缓存
+func (t *Telegram) fileURL(key string) (string, error) {
+	global.URLCacheMux.RLock()
+	cache, exists := global.URLCache[key]
+	global.URLCacheMux.RUnlock()
+	if exists && time.Now().Before(cache.ExpiresAt) {
+		return cache.URL, nil
+	}
+
+	bot, fileID, err := t.resolve(key)
+	if err != nil {
+		return "", err
+	}
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return "", err
+	}
+	url := t.link(bot, file)
+
+	global.URLCacheMux.Lock()
+	global.URLCache[key] = &global.FileURLCache{
+		URL:       url,
+		ExpiresAt: time.Now().Add(global.URLCacheTime),
+	}
+	global.URLCacheMux.Unlock()
+	return url, nil
+}
+
+// link 根据 FileEndpoint 生成下载地址，file_path 为绝对路径时原样返回
+func (t *Telegram) link(bot *tgbotapi.BotAPI, file tgbotapi.File) string {
+	if filepath.IsAbs(file.FilePath) {
+		return file.FilePath
+	}
+	return fmt.Sprintf(t.FileEndpoint, bot.Token, file.FilePath)
+}
+
+func (t *Telegram) openLocal(key, path string) (io.ReadCloser, *Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.forget(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &Object{Key: key, URL: path, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (target *telegramTarget) available() bool {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	return time.Now().After(target.downUntil)
+}
+
+// markFailed 根据错误类型暂停使用该目标：限流按 retry_after 等待，
+// bot 被撤销或被移出频道时暂停较长时间，其他错误只跳过本次
+func (target *telegramTarget) markFailed(err error) {
+	var wait time.Duration
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		msg := strings.ToLower(apiErr.Message)
+		switch {
+		case apiErr.RetryAfter > 0:
+			wait = time.Duration(apiErr.RetryAfter) * time.Second
+		case strings.Contains(msg, "too many requests"):
+			wait = 30 * time.Second
+		case strings.Contains(msg, "unauthorized"),
+			strings.Contains(msg, "forbidden"),
+			strings.Contains(msg, "chat not found"):
+			wait = 10 * time.Minute
+		}
+	}
+	if wait == 0 {
+		return
+	}
+
+	target.mu.Lock()
+	target.downUntil = time.Now().Add(wait)
+	target.mu.Unlock()
+}
+
+// rereadable 返回可重复读取的内容，r 不支持随机读取时先写入临时文件
+func rereadable(r io.Reader, size int64) (*io.SectionReader, func(), error) {
+	if ra, ok := r.(io.ReaderAt); ok && size >= 0 {
+		var offset int64
+		if seeker, ok := r.(io.Seeker); ok {
+			pos, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, nil, err
+			}
+			offset = pos
+		}
+		return io.NewSectionReader(ra, offset, size), func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "telegram-put-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return io.NewSectionReader(tmp, 0, n), cleanup, nil
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"log"
+	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
@@ -9,9 +10,62 @@ import (
 )
 
 func InitTelegram() {
-	var err error
-	global.Bot, err = tgbotapi.NewBotAPI(global.AppConfig.Telegram.Token)
-	if err != nil {
-		log.Fatal(err)
+	// 未使用 Telegram 存储时不连接 Telegram，便于在无法访问 Telegram 的内网部署
+	if !global.AppConfig.UsesBackend("telegram") {
+		return
 	}
+	Connect()
+}
+
+// Connect 连接配置中的所有 bot
+func Connect() {
+	// 额外的 bot 连接失败只记录日志，上传时会跳过
+	for i, token := range global.AppConfig.TelegramTokens() {
+		bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, APIEndpoint())
+		if err != nil {
+			if token == global.AppConfig.Telegram.Token {
+				log.Fatal(err)
+			}
+			log.Printf("Failed to connect telegram bot #%d: %v", i+1, err)
+			continue
+		}
+		if token == global.AppConfig.Telegram.Token {
+			global.Bot = bot
+		}
+		global.Bots = append(global.Bots, bot)
+	}
+	if len(global.Bots) == 0 {
+		log.Fatal("No telegram bot configured")
+	}
+	if global.Bot == nil {
+		global.Bot = global.Bots[0]
+	}
+}
+
+// APIEndpoint 返回 Bot API 地址格式，未配置时使用官方地址
+func APIEndpoint() string {
+	endpoint := global.AppConfig.Telegram.APIEndpoint
+	if endpoint == "" {
+		return tgbotapi.APIEndpoint
+	}
+	return endpointFormat(endpoint, "/bot%s/%s")
+}
+
+// FileEndpoint 返回文件下载地址格式，未配置时跟随 APIEndpoint
+func FileEndpoint() string {
+	if endpoint := global.AppConfig.Telegram.FileEndpoint; endpoint != "" {
+		return endpointFormat(endpoint, "/file/bot%s/%s")
+	}
+	if endpoint := global.AppConfig.Telegram.APIEndpoint; endpoint != "" && !strings.Contains(endpoint, "%s") {
+		return endpointFormat(endpoint, "/file/bot%s/%s")
+	}
+	return tgbotapi.FileEndpoint
+}
+
+// endpointFormat 将 http://host:port 形式的地址补全为格式字符串，已包含 %s 的保持不变
+func endpointFormat(endpoint, suffix string) string {
+	if strings.Contains(endpoint, "%s") {
+		return endpoint
+	}
+	return strings.TrimRight(endpoint, "/") + suffix
 }
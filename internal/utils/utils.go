@@ -2,7 +2,11 @@ package utils
 
 import (
 	"fmt"
+	"log"
+	"mime"
 	"net"
+	"net/http"
+	"net/netip"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -12,8 +16,21 @@ import (
 )
 
 func GetFileExtension(mimeType string) (string, bool) {
-	ext, ok := global.AllowedMimeTypes[mimeType]
-	return ext, ok
+	ext, ok := global.MimeTypeExtensions[mimeType]
+	return ext, ok && global.AppConfig.AllowsType(mimeType)
+}
+
+var validExtension = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// GetGenericFileExtension 返回非图片文件的扩展名，优先使用原文件名中的扩展名
+func GetGenericFileExtension(filename, mimeType string) string {
+	if ext := NormalizeFileExtension(filename); validExtension.MatchString(ext) {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
 }
 
 func NormalizeFileExtension(filename string) string {
@@ -24,6 +41,61 @@ func NormalizeFileExtension(filename string) string {
 	return ext
 }
 
+// trustedProxies 允许设置 X-Forwarded-For 的反向代理地址
+var trustedProxies []netip.Prefix
+
+// InitTrustedProxies 解析 security.trustedProxies 配置，每项可以是 IP 地址或 CIDR
+func InitTrustedProxies() {
+	trustedProxies = nil
+	for _, s := range global.AppConfig.Security.TrustedProxies {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				log.Fatal("Invalid trusted proxy:", s)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+}
+
+func trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 返回请求的客户端地址。只有直接连接的地址是受信任的反向代理时才读取 X-Forwarded-For，
+// 从右向左跳过受信任的代理，取第一个不受信任的地址，客户端自行添加的地址不会被采用
+func ClientIP(r *http.Request) string {
+	remote := ValidateIPAddress(r.RemoteAddr)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !trustedProxy(addr.Unmap()) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// 无法解析时使用最后一个可信的地址
+			break
+		}
+		remote = hop.Unmap().String()
+		if !trustedProxy(hop.Unmap()) {
+			break
+		}
+	}
+	return remote
+}
+
 func ValidateIPAddress(ip string) string {
 	if host, _, err := net.SplitHostPort(ip); err == nil {
 		ip = host
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"hosting/internal/global"
+)
+
+func TestClientIP(t *testing.T) {
+	global.AppConfig.Security.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+	InitTrustedProxies()
+	t.Cleanup(func() {
+		global.AppConfig.Security.TrustedProxies = nil
+		InitTrustedProxies()
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer ignores header", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:80", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"client supplied hops are skipped", "10.0.0.2:80", []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"chained proxies", "10.0.0.2:80", []string{"1.2.3.4, 10.1.1.1", "10.2.2.2"}, "1.2.3.4"},
+		{"ipv6 proxy", "[::1]:80", []string{"2001:db8::5"}, "2001:db8::5"},
+		{"mapped address", "10.0.0.2:80", []string{"::ffff:1.2.3.4"}, "1.2.3.4"},
+		{"invalid hop", "10.0.0.2:80", []string{"garbage, 10.1.1.1"}, "10.1.1.1"},
+		{"only proxies", "10.0.0.2:80", []string{"10.3.3.3"}, "10.3.3.3"},
+		{"no header", "10.0.0.2:80", nil, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}